@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	ConditionExitNonZero = "exit_nonzero"
+	ConditionOOM         = "oom"
+	ConditionRestartLoop = "restart_loop"
+	ConditionUnhealthy   = "unhealthy"
+
+	SinkTypeWebhook = "webhook"
+	SinkTypeSlack   = "slack"
+	SinkTypeSMTP    = "smtp"
+)
+
+type AlertsConfig struct {
+	Rules []AlertRuleConfig `json:"rules" yaml:"rules"`
+	Sinks []AlertSinkConfig `json:"sinks" yaml:"sinks"`
+}
+
+type AlertRuleConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Conditions are exit_nonzero, oom, restart_loop and unhealthy.
+	Conditions []string `json:"conditions" yaml:"conditions"`
+	// Names are glob patterns of container names the rule applies to, all
+	// containers when empty.
+	Names []string `json:"names" yaml:"names"`
+	// Labels must all be set to the given values on the container, a value
+	// of * matches any value.
+	Labels map[string]string `json:"labels" yaml:"labels"`
+	// RestartCount starts within RestartWindow is a restart loop.
+	RestartCount  int      `json:"restartCount" yaml:"restartCount"`
+	RestartWindow Duration `json:"restartWindow" yaml:"restartWindow"`
+	// Debounce is the least time between two alerts for the same container
+	// and condition.
+	Debounce Duration `json:"debounce" yaml:"debounce"`
+	Sinks    []string `json:"sinks" yaml:"sinks"`
+}
+
+type AlertSinkConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Type is webhook, slack or smtp.
+	Type string `json:"type" yaml:"type"`
+	URL  string `json:"url" yaml:"url"`
+	// SMTP settings, Addr is host:port.
+	Addr     string   `json:"addr" yaml:"addr"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	From     string   `json:"from" yaml:"from"`
+	To       []string `json:"to" yaml:"to"`
+}
+
+func (ac AlertsConfig) Validate() error {
+	sinks := map[string]bool{}
+	for _, s := range ac.Sinks {
+		if s.Name == "" || sinks[s.Name] {
+			return fmt.Errorf("alert sink names must be unique and not empty, got %q", s.Name)
+		}
+		sinks[s.Name] = true
+		switch s.Type {
+		case SinkTypeWebhook, SinkTypeSlack:
+			if s.URL == "" {
+				return fmt.Errorf("alert sink %s has no URL", s.Name)
+			}
+		case SinkTypeSMTP:
+			if s.Addr == "" || s.From == "" || len(s.To) == 0 {
+				return fmt.Errorf("alert sink %s needs addr, from and to", s.Name)
+			}
+		default:
+			return fmt.Errorf("alert sink %s has unknown type %q", s.Name, s.Type)
+		}
+	}
+	for _, r := range ac.Rules {
+		if r.Name == "" {
+			return errors.New("alert rules must have a name")
+		}
+		if len(r.Conditions) == 0 {
+			return fmt.Errorf("alert rule %s has no conditions", r.Name)
+		}
+		for _, c := range r.Conditions {
+			switch c {
+			case ConditionExitNonZero, ConditionOOM, ConditionRestartLoop, ConditionUnhealthy:
+			default:
+				return fmt.Errorf("alert rule %s has unknown condition %q", r.Name, c)
+			}
+		}
+		for _, p := range r.Names {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("alert rule %s: name pattern %q: %w", r.Name, p, err)
+			}
+		}
+		if r.RestartCount < 0 || r.RestartWindow < 0 || r.Debounce < 0 {
+			return fmt.Errorf("alert rule %s: restart count, window and debounce can not be negative", r.Name)
+		}
+		if len(r.Sinks) == 0 {
+			return fmt.Errorf("alert rule %s has no sinks", r.Name)
+		}
+		for _, s := range r.Sinks {
+			if !sinks[s] {
+				return fmt.Errorf("alert rule %s uses unknown sink %q", r.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+type Alert struct {
+	Rule          string    `json:"rule"`
+	Condition     string    `json:"condition"`
+	ContainerID   string    `json:"containerId"`
+	ContainerName string    `json:"containerName"`
+	Image         string    `json:"image,omitempty"`
+	ExitCode      string    `json:"exitCode,omitempty"`
+	Message       string    `json:"message"`
+	Time          time.Time `json:"time"`
+}
+
+type AlertNotifier interface {
+	Notify(a Alert) error
+}
+
+// WebhookNotifier posts the alert as JSON.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (wn WebhookNotifier) Notify(a Alert) error {
+	return postJSON(wn.Client, wn.URL, a)
+}
+
+// SlackNotifier posts the alert message to a Slack compatible incoming
+// webhook.
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (sn SlackNotifier) Notify(a Alert) error {
+	return postJSON(sn.Client, sn.URL, map[string]string{"text": a.Message})
+}
+
+func postJSON(client *http.Client, url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+type SMTPNotifier struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (sn SMTPNotifier) Notify(a Alert) error {
+	var auth smtp.Auth
+	if sn.Username != "" {
+		host, _, _ := net.SplitHostPort(sn.Addr)
+		auth = smtp.PlainAuth("", sn.Username, sn.Password, host)
+	}
+	body, _ := json.MarshalIndent(a, "", "  ")
+	msg := "From: " + sn.From + "\r\n" +
+		"To: " + strings.Join(sn.To, ", ") + "\r\n" +
+		"Subject: [conman] " + a.Message + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + a.Message + "\r\n\r\n" + string(body) + "\r\n"
+	return smtp.SendMail(sn.Addr, auth, sn.From, sn.To, []byte(msg))
+}
+
+func newAlertNotifier(cfg AlertSinkConfig) AlertNotifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch cfg.Type {
+	case SinkTypeSlack:
+		return SlackNotifier{URL: cfg.URL, Client: client}
+	case SinkTypeSMTP:
+		return SMTPNotifier{Addr: cfg.Addr, Username: cfg.Username, Password: cfg.Password, From: cfg.From, To: cfg.To}
+	}
+	return WebhookNotifier{URL: cfg.URL, Client: client}
+}
+
+type alertRule struct {
+	AlertRuleConfig
+	conditions map[string]bool
+	notifiers  map[string]AlertNotifier
+}
+
+func (ar *alertRule) matches(name string, attrs map[string]string) bool {
+	if len(ar.Names) > 0 {
+		matched := false
+		for _, p := range ar.Names {
+			if ok, _ := path.Match(p, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for k, v := range ar.Labels {
+		lv, ok := attrs[k]
+		if !ok || (v != "*" && v != lv) {
+			return false
+		}
+	}
+	return true
+}
+
+// Alerter follows the Docker event stream and notifies the sinks of the rules
+// matching containers that exit non-zero, are OOM killed, restart in a loop
+// or turn unhealthy.
+type Alerter struct {
+	rules  []*alertRule
+	errLog *log.Logger
+
+	mu     sync.Mutex
+	fired  map[string]time.Time
+	starts map[string][]time.Time
+}
+
+func NewAlerter(cfg AlertsConfig, errLog *log.Logger) *Alerter {
+	notifiers := map[string]AlertNotifier{}
+	for _, s := range cfg.Sinks {
+		notifiers[s.Name] = newAlertNotifier(s)
+	}
+	a := &Alerter{errLog: errLog, fired: map[string]time.Time{}, starts: map[string][]time.Time{}}
+	for _, rc := range cfg.Rules {
+		if rc.RestartCount == 0 {
+			rc.RestartCount = 3
+		}
+		if rc.RestartWindow == 0 {
+			rc.RestartWindow = Duration(5 * time.Minute)
+		}
+		ar := &alertRule{AlertRuleConfig: rc, conditions: map[string]bool{}, notifiers: map[string]AlertNotifier{}}
+		for _, c := range rc.Conditions {
+			ar.conditions[c] = true
+		}
+		for _, s := range rc.Sinks {
+			ar.notifiers[s] = notifiers[s]
+		}
+		a.rules = append(a.rules, ar)
+	}
+	return a
+}
+
+// Run follows the event stream until ctx is done, reconnecting when the
+// stream breaks.
+func (a *Alerter) Run(ctx context.Context) {
+	for {
+		err := a.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		a.errLog.Printf("following Docker events: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (a *Alerter) follow(ctx context.Context) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	msgs, errs := cli.Events(ctx, types.EventsOptions{Filters: args})
+	for {
+		select {
+		case msg := <-msgs:
+			a.handle(msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+func (a *Alerter) handle(msg events.Message) {
+	now := time.Unix(0, msg.TimeNano)
+	if msg.TimeNano == 0 {
+		now = time.Now()
+	}
+	attrs := msg.Actor.Attributes
+	condition := ""
+	switch msg.Action {
+	case "die":
+		if code := attrs["exitCode"]; code != "" && code != "0" {
+			condition = ConditionExitNonZero
+		}
+	case "oom":
+		condition = ConditionOOM
+	case "start":
+		if a.startedInLoop(msg.Actor.ID, now) {
+			condition = ConditionRestartLoop
+		}
+	case "health_status: unhealthy":
+		condition = ConditionUnhealthy
+	case "destroy":
+		a.forget(msg.Actor.ID)
+	}
+	if condition == "" {
+		return
+	}
+
+	alert := Alert{
+		Condition:     condition,
+		ContainerID:   msg.Actor.ID,
+		ContainerName: attrs["name"],
+		Image:         attrs["image"],
+		Time:          now,
+	}
+	switch condition {
+	case ConditionExitNonZero:
+		alert.ExitCode = attrs["exitCode"]
+		alert.Message = fmt.Sprintf("container %s exited with code %s", alert.ContainerName, alert.ExitCode)
+	case ConditionOOM:
+		alert.Message = fmt.Sprintf("container %s was killed for running out of memory", alert.ContainerName)
+	case ConditionRestartLoop:
+		alert.Message = fmt.Sprintf("container %s is restarting in a loop", alert.ContainerName)
+	case ConditionUnhealthy:
+		alert.Message = fmt.Sprintf("container %s is unhealthy", alert.ContainerName)
+	}
+
+	for _, rule := range a.rules {
+		if !rule.conditions[condition] || !rule.matches(alert.ContainerName, attrs) {
+			continue
+		}
+		if condition == ConditionRestartLoop && !a.countStarts(msg.Actor.ID, now, rule) {
+			continue
+		}
+		if !a.debounce(rule, alert, now) {
+			continue
+		}
+		ra := alert
+		ra.Rule = rule.Name
+		for name, n := range rule.notifiers {
+			go func(name string, n AlertNotifier) {
+				if err := n.Notify(ra); err != nil {
+					a.errLog.Printf("alert sink %s: %v", name, err)
+				}
+			}(name, n)
+		}
+	}
+}
+
+// startedInLoop records a start and reports whether the container has started
+// more than once recently, rules then decide on their own count and window.
+func (a *Alerter) startedInLoop(id string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var longest time.Duration
+	for _, r := range a.rules {
+		if time.Duration(r.RestartWindow) > longest {
+			longest = time.Duration(r.RestartWindow)
+		}
+	}
+	starts := []time.Time{}
+	for _, t := range a.starts[id] {
+		if now.Sub(t) <= longest {
+			starts = append(starts, t)
+		}
+	}
+	a.starts[id] = append(starts, now)
+	return len(a.starts[id]) > 1
+}
+
+func (a *Alerter) countStarts(id string, now time.Time, rule *alertRule) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := 0
+	for _, t := range a.starts[id] {
+		if now.Sub(t) <= time.Duration(rule.RestartWindow) {
+			n++
+		}
+	}
+	return n >= rule.RestartCount
+}
+
+func (a *Alerter) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.starts, id)
+	for key := range a.fired {
+		if strings.Contains(key, "\xff"+id+"\xff") {
+			delete(a.fired, key)
+		}
+	}
+}
+
+func (a *Alerter) debounce(rule *alertRule, alert Alert, now time.Time) bool {
+	key := rule.Name + "\xff" + alert.ContainerID + "\xff" + alert.Condition
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.fired[key]; ok && now.Sub(last) < time.Duration(rule.Debounce) {
+		return false
+	}
+	a.fired[key] = now
+	return true
+}
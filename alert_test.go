@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func containerEvent(action, id, name string, at time.Time, attrs map[string]string) events.Message {
+	a := map[string]string{"name": name, "image": "nginx:latest"}
+	for k, v := range attrs {
+		a[k] = v
+	}
+	return events.Message{Type: events.ContainerEventType, Action: action, Actor: events.Actor{ID: id, Attributes: a}, TimeNano: at.UnixNano()}
+}
+
+// webhookReceiver collects the alerts posted to it.
+func webhookReceiver(t *testing.T) (*httptest.Server, chan Alert) {
+	alerts := make(chan Alert, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decoding alert: %v", err)
+		}
+		alerts <- a
+	}))
+	t.Cleanup(srv.Close)
+	return srv, alerts
+}
+
+func expectAlerts(t *testing.T, alerts chan Alert, n int) []Alert {
+	t.Helper()
+	got := []Alert{}
+	for len(got) < n {
+		select {
+		case a := <-alerts:
+			got = append(got, a)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d alerts, want %d", len(got), n)
+		}
+	}
+	select {
+	case a := <-alerts:
+		t.Fatalf("unexpected alert %+v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+	return got
+}
+
+func TestAlerterWebhookDebounce(t *testing.T) {
+	srv, alerts := webhookReceiver(t)
+	a := NewAlerter(AlertsConfig{
+		Sinks: []AlertSinkConfig{{Name: "hook", Type: SinkTypeWebhook, URL: srv.URL}},
+		Rules: []AlertRuleConfig{{Name: "crash", Conditions: []string{ConditionExitNonZero}, Names: []string{"web*"}, Debounce: Duration(time.Minute), Sinks: []string{"hook"}}},
+	}, log.New(ioutil.Discard, "", 0))
+
+	now := time.Now()
+	a.handle(containerEvent("die", "c1", "web", now, map[string]string{"exitCode": "0"}))
+	a.handle(containerEvent("die", "c2", "db", now, map[string]string{"exitCode": "1"}))
+	a.handle(containerEvent("die", "c1", "web", now, map[string]string{"exitCode": "137"}))
+	got := expectAlerts(t, alerts, 1)
+	if got[0].Rule != "crash" || got[0].ContainerID != "c1" || got[0].ExitCode != "137" {
+		t.Errorf("alert %+v", got[0])
+	}
+
+	// Within the debounce time of the first alert.
+	a.handle(containerEvent("die", "c1", "web", now.Add(30*time.Second), map[string]string{"exitCode": "1"}))
+	expectAlerts(t, alerts, 0)
+
+	a.handle(containerEvent("die", "c1", "web", now.Add(2*time.Minute), map[string]string{"exitCode": "1"}))
+	expectAlerts(t, alerts, 1)
+}
+
+func TestAlerterRestartLoop(t *testing.T) {
+	srv, alerts := webhookReceiver(t)
+	a := NewAlerter(AlertsConfig{
+		Sinks: []AlertSinkConfig{{Name: "hook", Type: SinkTypeWebhook, URL: srv.URL}},
+		Rules: []AlertRuleConfig{{Name: "loop", Conditions: []string{ConditionRestartLoop}, Labels: map[string]string{"team": "*"}, Sinks: []string{"hook"}}},
+	}, log.New(ioutil.Discard, "", 0))
+
+	now := time.Now()
+	labels := map[string]string{"team": "web"}
+	a.handle(containerEvent("start", "c1", "web", now, labels))
+	a.handle(containerEvent("start", "c1", "web", now.Add(time.Minute), labels))
+	expectAlerts(t, alerts, 0)
+	a.handle(containerEvent("start", "c1", "web", now.Add(2*time.Minute), labels))
+	got := expectAlerts(t, alerts, 1)
+	if got[0].Condition != ConditionRestartLoop {
+		t.Errorf("alert %+v", got[0])
+	}
+
+	// Starts of unlabelled containers do not match the rule.
+	for i := 0; i < 3; i++ {
+		a.handle(containerEvent("start", "c2", "db", now.Add(time.Duration(i)*time.Minute), nil))
+	}
+	expectAlerts(t, alerts, 0)
+}
+
+// smtpReceiver accepts mail without authentication and sends the data of
+// every message on the returned channel.
+func smtpReceiver(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestAlerterSMTP(t *testing.T) {
+	addr, messages := smtpReceiver(t)
+	a := NewAlerter(AlertsConfig{
+		Sinks: []AlertSinkConfig{{Name: "mail", Type: SinkTypeSMTP, Addr: addr, From: "conman@example.com", To: []string{"ops@example.com"}}},
+		Rules: []AlertRuleConfig{{Name: "oom", Conditions: []string{ConditionOOM}, Debounce: Duration(time.Hour), Sinks: []string{"mail"}}},
+	}, log.New(ioutil.Discard, "", 0))
+
+	now := time.Now()
+	a.handle(containerEvent("oom", "c1", "web", now, nil))
+	a.handle(containerEvent("oom", "c1", "web", now.Add(time.Minute), nil))
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Subject: [conman] container web was killed for running out of memory") || !strings.Contains(msg, "To: ops@example.com") {
+			t.Errorf("message %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail received")
+	}
+	select {
+	case msg := <-messages:
+		t.Errorf("debounced alert was mailed: %q", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
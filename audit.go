@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeDenied  = "denied"
+	AuditOutcomeFailure = "failure"
+
+	auditRecentSize = 1000
+	auditQueueSize  = 256
+)
+
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Identity   string    `json:"identity,omitempty"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"targetType"`
+	TargetID   string    `json:"targetId"`
+	TargetName string    `json:"targetName,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditSink is a destination for audit records, such as a file or syslog.
+type AuditSink interface {
+	Write(rec AuditRecord) error
+}
+
+// AuditLog keeps the most recent records in memory for the audit API and
+// hands every record to its sinks on a background goroutine, so a slow sink
+// never holds up the request being audited. Records that do not fit in the
+// queue are only kept in memory, and counted as dropped.
+type AuditLog struct {
+	sinks  []AuditSink
+	errLog *log.Logger
+	queue  chan AuditRecord
+	done   chan struct{}
+
+	mu      sync.Mutex
+	recent  []AuditRecord
+	dropped int
+	closed  bool
+}
+
+func NewAuditLog(errLog *log.Logger, sinks ...AuditSink) *AuditLog {
+	al := &AuditLog{
+		sinks:  sinks,
+		errLog: errLog,
+		queue:  make(chan AuditRecord, auditQueueSize),
+		done:   make(chan struct{}),
+	}
+	go al.run()
+	return al
+}
+
+func (al *AuditLog) run() {
+	defer close(al.done)
+	for rec := range al.queue {
+		for _, sink := range al.sinks {
+			if err := sink.Write(rec); err != nil {
+				al.errLog.Printf("audit sink %T: %v", sink, err)
+			}
+		}
+	}
+}
+
+func (al *AuditLog) Record(rec AuditRecord) {
+	al.mu.Lock()
+	if len(al.recent) == auditRecentSize {
+		copy(al.recent, al.recent[1:])
+		al.recent = al.recent[:auditRecentSize-1]
+	}
+	al.recent = append(al.recent, rec)
+	// Handlers cut off by a timeout may still record after Close.
+	if al.closed {
+		al.mu.Unlock()
+		al.errLog.Printf("audit log closed, record of %s on %s %s not written to the sinks", rec.Action, rec.TargetType, rec.TargetID)
+		return
+	}
+	select {
+	case al.queue <- rec:
+		al.mu.Unlock()
+	default:
+		al.dropped++
+		dropped := al.dropped
+		al.mu.Unlock()
+		al.errLog.Printf("audit queue full, record of %s on %s %s not written to the sinks, %d dropped in total", rec.Action, rec.TargetType, rec.TargetID, dropped)
+	}
+}
+
+// Recent returns up to limit records, newest first.
+func (al *AuditLog) Recent(limit int) []AuditRecord {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if limit <= 0 || limit > len(al.recent) {
+		limit = len(al.recent)
+	}
+	records := make([]AuditRecord, 0, limit)
+	for i := len(al.recent) - 1; i >= 0 && len(records) < limit; i-- {
+		records = append(records, al.recent[i])
+	}
+	return records
+}
+
+// Close flushes queued records to the sinks and closes the ones that hold
+// resources.
+func (al *AuditLog) Close() error {
+	al.mu.Lock()
+	al.closed = true
+	close(al.queue)
+	al.mu.Unlock()
+	<-al.done
+	for _, sink := range al.sinks {
+		if c, ok := sink.(interface{ Close() error }); ok {
+			if err := c.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func auditWrapper(audit *AuditLog, auth Authenticator, action, targetType string, fn func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		rec := AuditRecord{
+			Time:       time.Now(),
+			RequestID:  requestID(r),
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+			Identity:   auth.Subject(r),
+			Action:     action,
+			TargetType: targetType,
+			TargetID:   mux.Vars(r)["id"],
+		}
+		// The name is looked up before the action runs since the target
+		// might not exist afterwards.
+		if rec.TargetID != "" {
+			rec.TargetName = lookupTargetName(r.Context(), targetType, rec.TargetID)
+		}
+
+		target := &auditTarget{}
+		err := fn(w, r.WithContext(context.WithValue(r.Context(), auditTargetKey{}, target)))
+		if target.id != "" {
+			rec.TargetID, rec.TargetName = target.id, target.name
+		}
+		switch {
+		case err == nil:
+			rec.Outcome = AuditOutcomeSuccess
+		case classifyError(err).Status == http.StatusForbidden:
+			rec.Outcome = AuditOutcomeDenied
+		default:
+			rec.Outcome = AuditOutcomeFailure
+			rec.Error = err.Error()
+		}
+		audit.Record(rec)
+		return err
+	}
+}
+
+type auditTargetKey struct{}
+
+type auditTarget struct {
+	id   string
+	name string
+}
+
+// setAuditTarget records the target of an action creating it, its ID is not
+// known before the action runs.
+func setAuditTarget(r *http.Request, id, name string) {
+	if t, ok := r.Context().Value(auditTargetKey{}).(*auditTarget); ok {
+		t.id, t.name = id, name
+	}
+}
+
+func lookupTargetName(ctx context.Context, targetType, id string) string {
+	cli, err := newDockerClient()
+	if err != nil {
+		return ""
+	}
+	switch targetType {
+	case "container":
+		cjson, err := cli.ContainerInspect(ctx, id)
+		if err != nil || len(cjson.Name) < 1 {
+			return ""
+		}
+		return cjson.Name[1:]
+	case "service":
+		svc, _, err := cli.ServiceInspectWithRaw(ctx, id)
+		if err != nil {
+			return ""
+		}
+		return svc.Spec.Name
+	}
+	return ""
+}
+
+func ListAuditRecords(auth Authenticator, audit *AuditLog) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		limit, err := queryLimit(r, 100)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(audit.Recent(limit))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WriterAuditSink writes one JSON record per line, used for stdout.
+type WriterAuditSink struct {
+	W io.Writer
+}
+
+func (was WriterAuditSink) Write(rec AuditRecord) error {
+	return json.NewEncoder(was.W).Encode(rec)
+}
+
+// FileAuditSink appends JSON lines to a file and rotates it once it grows
+// beyond MaxSize bytes, keeping MaxBackups old files named path.1, path.2 and
+// so on.
+type FileAuditSink struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	fas := &FileAuditSink{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := fas.open(); err != nil {
+		return nil, err
+	}
+	return fas, nil
+}
+
+func (fas *FileAuditSink) open() error {
+	f, err := os.OpenFile(fas.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fas.file = f
+	fas.size = fi.Size()
+	return nil
+}
+
+func (fas *FileAuditSink) rotate() error {
+	if err := fas.file.Close(); err != nil {
+		return err
+	}
+	if fas.MaxBackups > 0 {
+		for i := fas.MaxBackups - 1; i > 0; i-- {
+			os.Rename(fas.backupName(i), fas.backupName(i+1))
+		}
+		if err := os.Rename(fas.Path, fas.backupName(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(fas.Path); err != nil {
+		return err
+	}
+	return fas.open()
+}
+
+func (fas *FileAuditSink) backupName(n int) string {
+	return fas.Path + "." + strconv.Itoa(n)
+}
+
+func (fas *FileAuditSink) Write(rec AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	fas.mu.Lock()
+	defer fas.mu.Unlock()
+	if fas.MaxSize > 0 && fas.size > 0 && fas.size+int64(len(b)) > fas.MaxSize {
+		if err := fas.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := fas.file.Write(b)
+	fas.size += int64(n)
+	return err
+}
+
+func (fas *FileAuditSink) Close() error {
+	fas.mu.Lock()
+	defer fas.mu.Unlock()
+	return fas.file.Close()
+}
+
+// WebhookAuditSink posts each record as JSON to URL.
+type WebhookAuditSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookAuditSink(url string) WebhookAuditSink {
+	return WebhookAuditSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (was WebhookAuditSink) Write(rec AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	resp, err := was.Client.Post(was.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", was.URL, resp.Status)
+	}
+	return nil
+}
+
+// NewAuditSinks sets up the sinks enabled in the audit configuration.
+func NewAuditSinks(cfg AuditConfig) ([]AuditSink, error) {
+	sinks := []AuditSink{}
+	if cfg.Stdout {
+		sinks = append(sinks, WriterAuditSink{W: os.Stdout})
+	}
+	if cfg.File != "" {
+		fas, err := NewFileAuditSink(cfg.File, int64(cfg.FileMaxSizeMB)*1024*1024, cfg.FileMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, fas)
+	}
+	if cfg.Syslog {
+		sas, err := NewSyslogAuditSink(cfg.SyslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sas)
+	}
+	if cfg.Webhook != "" {
+		sinks = append(sinks, NewWebhookAuditSink(cfg.Webhook))
+	}
+	return sinks, nil
+}
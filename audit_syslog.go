@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"strings"
+)
+
+type SyslogAuditSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogAuditSink connects to the syslog daemon at addr, given as
+// network:address (udp:logs.example.com:514). An empty addr uses the local
+// syslog socket.
+func NewSyslogAuditSink(addr string) (*SyslogAuditSink, error) {
+	network := ""
+	if i := strings.Index(addr, ":"); i > -1 {
+		network, addr = addr[:i], addr[i+1:]
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_NOTICE|syslog.LOG_AUTH, "conman")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditSink{w: w}, nil
+}
+
+func (sas *SyslogAuditSink) Write(rec AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return sas.w.Notice(string(b))
+}
+
+func (sas *SyslogAuditSink) Close() error {
+	return sas.w.Close()
+}
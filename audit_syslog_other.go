@@ -0,0 +1,16 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "errors"
+
+type SyslogAuditSink struct{}
+
+func NewSyslogAuditSink(addr string) (*SyslogAuditSink, error) {
+	return nil, errors.New("syslog audit sink is not supported on this platform")
+}
+
+func (sas *SyslogAuditSink) Write(rec AuditRecord) error {
+	return nil
+}
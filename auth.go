@@ -3,12 +3,13 @@ package main
 import (
 	"context"
 	"net/http"
-
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
 )
 
+const RoleAdmin = "admin"
+
 type Authenticator interface {
+	Subject(r *http.Request) string
+	HasRole(r *http.Request, role string) bool
 	IsContainerAllowed(r *http.Request, containerID string) (bool, error)
 	IsServiceAllowed(r *http.Request, serviceID string) (bool, error)
 }
@@ -16,6 +17,14 @@ type Authenticator interface {
 type NoOpAuthenticator struct {
 }
 
+func (noa NoOpAuthenticator) Subject(r *http.Request) string {
+	return ""
+}
+
+func (noa NoOpAuthenticator) HasRole(r *http.Request, role string) bool {
+	return true
+}
+
 func (noa NoOpAuthenticator) IsContainerAllowed(r *http.Request, containerID string) (bool, error) {
 	return true, nil
 }
@@ -27,58 +36,112 @@ func (noa NoOpAuthenticator) IsServiceAllowed(r *http.Request, serviceID string)
 type HTTPHeaderAuthenticator struct {
 	HTTPHeader        string
 	ContainerLabelKey string
+	// Roles maps a role name to the subjects holding it.
+	Roles map[string][]string
 }
 
-func (hha HTTPHeaderAuthenticator) IsContainerAllowed(r *http.Request, containerID string) (bool, error) {
+func (hha HTTPHeaderAuthenticator) Subject(r *http.Request) string {
 	if len(r.Header[hha.HTTPHeader]) < 1 {
+		return ""
+	}
+	return r.Header[hha.HTTPHeader][0]
+}
+
+func (hha HTTPHeaderAuthenticator) HasRole(r *http.Request, role string) bool {
+	return subjectHasRole(hha.Roles, hha.Subject(r), role)
+}
+
+func (hha HTTPHeaderAuthenticator) IsContainerAllowed(r *http.Request, containerID string) (bool, error) {
+	return isContainerSubject(r.Context(), hha.ContainerLabelKey, hha.Subject(r), containerID)
+}
+
+func (hha HTTPHeaderAuthenticator) IsServiceAllowed(r *http.Request, serviceID string) (bool, error) {
+	return isServiceSubject(r.Context(), hha.ContainerLabelKey, hha.Subject(r), serviceID)
+}
+
+// ClientCertAuthenticator uses the common name of a verified TLS client
+// certificate as the subject.
+type ClientCertAuthenticator struct {
+	ContainerLabelKey string
+	// Roles maps a role name to the subjects holding it.
+	Roles map[string][]string
+}
+
+func (cca ClientCertAuthenticator) Subject(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 || len(r.TLS.VerifiedChains[0]) < 1 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+func (cca ClientCertAuthenticator) HasRole(r *http.Request, role string) bool {
+	return subjectHasRole(cca.Roles, cca.Subject(r), role)
+}
+
+func (cca ClientCertAuthenticator) IsContainerAllowed(r *http.Request, containerID string) (bool, error) {
+	return isContainerSubject(r.Context(), cca.ContainerLabelKey, cca.Subject(r), containerID)
+}
+
+func (cca ClientCertAuthenticator) IsServiceAllowed(r *http.Request, serviceID string) (bool, error) {
+	return isServiceSubject(r.Context(), cca.ContainerLabelKey, cca.Subject(r), serviceID)
+}
+
+func isContainerSubject(ctx context.Context, labelKey, subject, containerID string) (bool, error) {
+	if subject == "" {
 		return false, nil
 	}
-	subject := r.Header[hha.HTTPHeader][0]
-	labelValue, err := getContainerLabel(hha.ContainerLabelKey, containerID)
+	labelValue, err := getContainerLabel(ctx, labelKey, containerID)
 	if err != nil {
 		return false, err
 	}
 	return (subject == labelValue), nil
 }
 
-func (hha HTTPHeaderAuthenticator) IsServiceAllowed(r *http.Request, serviceID string) (bool, error) {
-	if len(r.Header[hha.HTTPHeader]) < 1 {
+func isServiceSubject(ctx context.Context, labelKey, subject, serviceID string) (bool, error) {
+	if subject == "" {
 		return false, nil
 	}
-	subject := r.Header[hha.HTTPHeader][0]
-	labelValue, err := getServiceLabel(hha.ContainerLabelKey, serviceID)
+	labelValue, err := getServiceLabel(ctx, labelKey, serviceID)
 	if err != nil {
 		return false, err
 	}
 	return (subject == labelValue), nil
 }
 
-func getContainerLabel(labelKey, containerID string) (labelValue string, err error) {
-	cli, err := client.NewEnvClient()
+func subjectHasRole(roles map[string][]string, subject, role string) bool {
+	if subject == "" {
+		return false
+	}
+	for _, s := range roles[role] {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func getContainerLabel(ctx context.Context, labelKey, containerID string) (labelValue string, err error) {
+	cli, err := newDockerClient()
 	if err != nil {
 		return
 	}
-	// TODO Is there no other way to get the labels, do I need to list?
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	c, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return
 	}
-	for _, container := range containers {
-		if container.ID == containerID {
-			labelValue = container.Labels[labelKey]
-			return
-		}
+	if c.Config != nil {
+		labelValue = c.Config.Labels[labelKey]
 	}
 	return
 }
 
-func getServiceLabel(labelKey, serviceID string) (labelValue string, err error) {
-	cli, err := client.NewEnvClient()
+func getServiceLabel(ctx context.Context, labelKey, serviceID string) (labelValue string, err error) {
+	cli, err := newDockerClient()
 	if err != nil {
 		return
 	}
 
-	svc, _, err := cli.ServiceInspectWithRaw(context.Background(), serviceID)
+	svc, _, err := cli.ServiceInspectWithRaw(ctx, serviceID)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	AuthModeNone = "none"
+	AuthModeHTTP = "http"
+	AuthModeTLS  = "tls"
+
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Config holds all settings of conman. Values are taken, in increasing order
+// of precedence, from the defaults, the configuration file, environment
+// variables and command line flags.
+type Config struct {
+	Listen  string `json:"listen" yaml:"listen"`
+	URLRoot string `json:"urlRoot" yaml:"urlRoot"`
+	// StaticDir serves the web UI from disk instead of the copy embedded in
+	// the binary, useful when working on the UI.
+	StaticDir string       `json:"staticDir" yaml:"staticDir"`
+	TLS       TLSConfig    `json:"tls" yaml:"tls"`
+	Server    ServerConfig `json:"server" yaml:"server"`
+	Docker    DockerConfig `json:"docker" yaml:"docker"`
+	Auth      AuthConfig   `json:"auth" yaml:"auth"`
+	Labels    LabelConfig  `json:"labels" yaml:"labels"`
+	Audit     AuditConfig  `json:"audit" yaml:"audit"`
+	// ContainerMetrics exports resource usage of all containers on /metrics.
+	ContainerMetrics ContainerMetricsConfig `json:"containerMetrics" yaml:"containerMetrics"`
+	Logs             LogsConfig             `json:"logs" yaml:"logs"`
+	// ImageUpdates flags containers whose image has a newer digest in its
+	// registry.
+	ImageUpdates ImageUpdatesConfig `json:"imageUpdates" yaml:"imageUpdates"`
+	// ContainerCreate is the policy for containers created through conman.
+	ContainerCreate ContainerCreateConfig `json:"containerCreate" yaml:"containerCreate"`
+	Templates       TemplatesConfig       `json:"templates" yaml:"templates"`
+	Files           FilesConfig           `json:"files" yaml:"files"`
+	// Registries stores the credentials images are pulled with.
+	Registries RegistriesConfig `json:"registries" yaml:"registries"`
+	Alerts     AlertsConfig     `json:"alerts" yaml:"alerts"`
+	// Webhooks are notified of successful actions, such as removing a
+	// container.
+	Webhooks []WebhookConfig `json:"webhooks" yaml:"webhooks"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	// ClientCAFile enables client certificate authentication against the CA
+	// certificates in the file.
+	ClientCAFile string `json:"clientCAFile" yaml:"clientCAFile"`
+	// ClientAuth is optional or require.
+	ClientAuth string `json:"clientAuth" yaml:"clientAuth"`
+	// RedirectListen is an address where plain HTTP requests are redirected
+	// to HTTPS.
+	RedirectListen string `json:"redirectListen" yaml:"redirectListen"`
+}
+
+type ServerConfig struct {
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	// RequestTimeout bounds all requests except streaming ones such as log
+	// downloads.
+	RequestTimeout        Duration `json:"requestTimeout" yaml:"requestTimeout"`
+	ShutdownTimeout       Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	MaxConcurrentRequests int      `json:"maxConcurrentRequests" yaml:"maxConcurrentRequests"`
+	// ListCacheTTL is how long container and service lists are served from
+	// cache, 0 disables caching.
+	ListCacheTTL Duration `json:"listCacheTTL" yaml:"listCacheTTL"`
+	// MetricsToken lets scrapers read /metrics with it as bearer token,
+	// otherwise only admins may.
+	MetricsToken string `json:"metricsToken" yaml:"metricsToken"`
+	// TrustProxyHeaders builds links with the X-Forwarded-Prefix, -Host and
+	// -Proto headers, only set it behind a proxy that sets them.
+	TrustProxyHeaders bool `json:"trustProxyHeaders" yaml:"trustProxyHeaders"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in the
+// configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) set(s string) error {
+	td, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(td)
+	return nil
+}
+
+type DockerConfig struct {
+	Host       string `json:"host" yaml:"host"`
+	APIVersion string `json:"apiVersion" yaml:"apiVersion"`
+	// CertPath is a directory holding ca.pem, cert.pem and key.pem used to
+	// connect to a TLS protected daemon.
+	CertPath  string `json:"certPath" yaml:"certPath"`
+	TLSVerify bool   `json:"tlsVerify" yaml:"tlsVerify"`
+}
+
+type AuthConfig struct {
+	Mode       string `json:"mode" yaml:"mode"`
+	HTTPHeader string `json:"httpHeader" yaml:"httpHeader"`
+	// Roles maps a role name to the subjects holding it.
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+}
+
+type LabelConfig struct {
+	// AuthID is the container and service label holding the subject allowed
+	// to manage it.
+	AuthID string `json:"authId" yaml:"authId"`
+}
+
+type ContainerMetricsConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled"`
+	Interval Duration `json:"interval" yaml:"interval"`
+	// Concurrency is the number of containers queried for stats at once.
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+}
+
+type ImageUpdatesConfig struct {
+	Enabled  bool     `json:"enabled" yaml:"enabled"`
+	Interval Duration `json:"interval" yaml:"interval"`
+	// InsecureRegistries are reached with plain HTTP.
+	InsecureRegistries []string `json:"insecureRegistries" yaml:"insecureRegistries"`
+}
+
+type LogsConfig struct {
+	// SearchConcurrency is the number of logs scanned at once by a search.
+	SearchConcurrency int `json:"searchConcurrency" yaml:"searchConcurrency"`
+}
+
+type TemplatesConfig struct {
+	// Dir holds the container templates, one YAML file each. It is read on
+	// every request so templates can be changed without a restart.
+	Dir string `json:"dir" yaml:"dir"`
+}
+
+// FilesConfig limits copying files out of and into containers.
+type FilesConfig struct {
+	MaxDownloadMB int `json:"maxDownloadMB" yaml:"maxDownloadMB"`
+	MaxUploadMB   int `json:"maxUploadMB" yaml:"maxUploadMB"`
+}
+
+type RegistriesConfig struct {
+	// File holds the credentials, encrypted with Key, a base64 encoded 32
+	// byte AES key. Managing credentials is disabled when empty.
+	File string `json:"file" yaml:"file"`
+	Key  string `json:"key" yaml:"key"`
+}
+
+type AuditConfig struct {
+	Stdout         bool   `json:"stdout" yaml:"stdout"`
+	File           string `json:"file" yaml:"file"`
+	FileMaxSizeMB  int    `json:"fileMaxSizeMB" yaml:"fileMaxSizeMB"`
+	FileMaxBackups int    `json:"fileMaxBackups" yaml:"fileMaxBackups"`
+	Syslog         bool   `json:"syslog" yaml:"syslog"`
+	// SyslogAddress is given as network:address, empty uses the local syslog.
+	SyslogAddress string `json:"syslogAddress" yaml:"syslogAddress"`
+	Webhook       string `json:"webhook" yaml:"webhook"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen: ":8080",
+		Server: ServerConfig{
+			ReadHeaderTimeout:     Duration(10 * time.Second),
+			IdleTimeout:           Duration(2 * time.Minute),
+			RequestTimeout:        Duration(30 * time.Second),
+			ShutdownTimeout:       Duration(15 * time.Second),
+			MaxConcurrentRequests: 256,
+			ListCacheTTL:          Duration(time.Second),
+		},
+		Docker: DockerConfig{
+			Host:       client.DefaultDockerHost,
+			APIVersion: client.DefaultVersion,
+		},
+		Auth: AuthConfig{
+			Mode:  AuthModeNone,
+			Roles: map[string][]string{},
+		},
+		Labels: LabelConfig{AuthID: "conman.auth.id"},
+		Audit: AuditConfig{
+			FileMaxSizeMB:  100,
+			FileMaxBackups: 5,
+		},
+		ContainerMetrics: ContainerMetricsConfig{
+			Interval:    Duration(15 * time.Second),
+			Concurrency: 4,
+		},
+		Logs:         LogsConfig{SearchConcurrency: 4},
+		ImageUpdates: ImageUpdatesConfig{Interval: Duration(time.Hour)},
+		Files:        FilesConfig{MaxDownloadMB: 512, MaxUploadMB: 64},
+	}
+}
+
+// LoadConfig builds the configuration from the command line arguments (without
+// the program name), the environment and the configuration file given by the
+// -config flag or CONMAN_CONFIG.
+func LoadConfig(args []string) (Config, error) {
+	// The flags are parsed twice, first only to find the configuration file
+	// and then on top of file and environment so that they take precedence.
+	pre := DefaultConfig()
+	var path string
+	if err := newFlagSet(&pre, &path).Parse(args); err != nil {
+		return pre, err
+	}
+	if path == "" {
+		path = os.Getenv("CONMAN_CONFIG")
+	}
+
+	cfg := DefaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := newFlagSet(&cfg, &path).Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func newFlagSet(cfg *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("conman", flag.ContinueOnError)
+	fs.StringVar(path, "config", *path, "configuration file, YAML or JSON")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "address to listen on")
+	fs.StringVar(&cfg.URLRoot, "url-root", cfg.URLRoot, "path prefix conman is served under")
+	fs.StringVar(&cfg.StaticDir, "static-dir", cfg.StaticDir, "serve the web UI from this directory instead of the embedded copy")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS certificate file")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS private key file")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "CA file to verify client certificates with")
+	fs.StringVar(&cfg.TLS.ClientAuth, "tls-client-auth", cfg.TLS.ClientAuth, "client certificates are optional or require(d)")
+	fs.StringVar(&cfg.TLS.RedirectListen, "tls-redirect-listen", cfg.TLS.RedirectListen, "address redirecting plain HTTP to HTTPS")
+	fs.DurationVar((*time.Duration)(&cfg.Server.RequestTimeout), "request-timeout", time.Duration(cfg.Server.RequestTimeout), "timeout of non-streaming requests")
+	fs.DurationVar((*time.Duration)(&cfg.Server.ShutdownTimeout), "shutdown-timeout", time.Duration(cfg.Server.ShutdownTimeout), "time to wait for requests on shutdown")
+	fs.IntVar(&cfg.Server.MaxConcurrentRequests, "max-concurrent-requests", cfg.Server.MaxConcurrentRequests, "requests served at once, 0 for no limit")
+	fs.DurationVar((*time.Duration)(&cfg.Server.ListCacheTTL), "list-cache-ttl", time.Duration(cfg.Server.ListCacheTTL), "time container and service lists are cached, 0 to disable")
+	fs.BoolVar(&cfg.Server.TrustProxyHeaders, "trust-proxy-headers", cfg.Server.TrustProxyHeaders, "build links with the X-Forwarded-Prefix, -Host and -Proto headers of a reverse proxy")
+	fs.StringVar(&cfg.Docker.Host, "docker-host", cfg.Docker.Host, "Docker daemon endpoint")
+	fs.StringVar(&cfg.Docker.APIVersion, "docker-api-version", cfg.Docker.APIVersion, "Docker API version")
+	fs.StringVar(&cfg.Docker.CertPath, "docker-cert-path", cfg.Docker.CertPath, "directory with Docker TLS certificates")
+	fs.BoolVar(&cfg.Docker.TLSVerify, "docker-tls-verify", cfg.Docker.TLSVerify, "verify the Docker daemon certificate")
+	fs.StringVar(&cfg.Auth.Mode, "auth", cfg.Auth.Mode, "authentication mode, none, http or tls")
+	fs.StringVar(&cfg.Auth.HTTPHeader, "auth-http-header", cfg.Auth.HTTPHeader, "HTTP header holding the subject")
+	fs.Var(roleFlag{roles: cfg.Auth.Roles, role: RoleAdmin}, "admins", "comma separated subjects with the admin role")
+	fs.Var(roleFlag{roles: cfg.Auth.Roles, role: RoleOperator}, "operators", "comma separated subjects with the operator role")
+	fs.Var(roleFlag{roles: cfg.Auth.Roles, role: RoleFiles}, "files-users", "comma separated subjects with the files role")
+	fs.StringVar(&cfg.Labels.AuthID, "label-auth-id", cfg.Labels.AuthID, "label holding the subject allowed to manage a container")
+	fs.BoolVar(&cfg.Audit.Stdout, "audit-stdout", cfg.Audit.Stdout, "write audit records to stdout")
+	fs.StringVar(&cfg.Audit.File, "audit-file", cfg.Audit.File, "file to write audit records to")
+	fs.StringVar(&cfg.Audit.Webhook, "audit-webhook", cfg.Audit.Webhook, "URL to post audit records to")
+	fs.BoolVar(&cfg.ContainerMetrics.Enabled, "container-metrics", cfg.ContainerMetrics.Enabled, "export container resource usage on /metrics")
+	fs.DurationVar((*time.Duration)(&cfg.ContainerMetrics.Interval), "container-metrics-interval", time.Duration(cfg.ContainerMetrics.Interval), "interval between container stats collections")
+	fs.IntVar(&cfg.ContainerMetrics.Concurrency, "container-metrics-concurrency", cfg.ContainerMetrics.Concurrency, "containers queried for stats at once")
+	fs.BoolVar(&cfg.ImageUpdates.Enabled, "image-updates", cfg.ImageUpdates.Enabled, "check registries for newer images of running containers")
+	fs.DurationVar((*time.Duration)(&cfg.ImageUpdates.Interval), "image-updates-interval", time.Duration(cfg.ImageUpdates.Interval), "interval between image update checks")
+	fs.IntVar(&cfg.Logs.SearchConcurrency, "log-search-concurrency", cfg.Logs.SearchConcurrency, "logs scanned at once by a log search")
+	fs.IntVar(&cfg.Files.MaxDownloadMB, "files-max-download-mb", cfg.Files.MaxDownloadMB, "largest download of container files in megabytes")
+	fs.IntVar(&cfg.Files.MaxUploadMB, "files-max-upload-mb", cfg.Files.MaxUploadMB, "largest upload of files into containers in megabytes")
+	fs.StringVar(&cfg.Registries.File, "registries-file", cfg.Registries.File, "file holding the encrypted registry credentials")
+	fs.StringVar(&cfg.Templates.Dir, "templates-dir", cfg.Templates.Dir, "directory holding container templates")
+	return fs
+}
+
+type roleFlag struct {
+	roles map[string][]string
+	role  string
+}
+
+func (rf roleFlag) String() string {
+	if rf.roles == nil {
+		return ""
+	}
+	return strings.Join(rf.roles[rf.role], ",")
+}
+
+func (rf roleFlag) Set(v string) error {
+	rf.roles[rf.role] = splitList(v)
+	return nil
+}
+
+func loadConfigFile(path string, cfg *Config) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, cfg)
+	default:
+		return fmt.Errorf("configuration file %s: unknown format, use .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return fmt.Errorf("configuration file %s: %w", path, err)
+	}
+	if cfg.Auth.Roles == nil {
+		cfg.Auth.Roles = map[string][]string{}
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	envString := func(key string, v *string) {
+		if s, ok := os.LookupEnv(key); ok {
+			*v = s
+		}
+	}
+	envString("CONMAN_LISTEN", &cfg.Listen)
+	envString("CONMAN_URL_ROOT", &cfg.URLRoot)
+	envString("CONMAN_STATIC_DIR", &cfg.StaticDir)
+	envString("CONMAN_TLS_CERT", &cfg.TLS.CertFile)
+	envString("CONMAN_TLS_KEY", &cfg.TLS.KeyFile)
+	envString("CONMAN_TLS_CLIENT_CA", &cfg.TLS.ClientCAFile)
+	envString("CONMAN_TLS_CLIENT_AUTH", &cfg.TLS.ClientAuth)
+	envString("CONMAN_TLS_REDIRECT_LISTEN", &cfg.TLS.RedirectListen)
+	if err := envDuration("CONMAN_REQUEST_TIMEOUT", &cfg.Server.RequestTimeout); err != nil {
+		return err
+	}
+	if err := envDuration("CONMAN_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_MAX_CONCURRENT_REQUESTS", &cfg.Server.MaxConcurrentRequests); err != nil {
+		return err
+	}
+	if err := envDuration("CONMAN_LIST_CACHE_TTL", &cfg.Server.ListCacheTTL); err != nil {
+		return err
+	}
+	envString("CONMAN_METRICS_TOKEN", &cfg.Server.MetricsToken)
+	if v, ok := os.LookupEnv("CONMAN_TRUST_PROXY_HEADERS"); ok {
+		cfg.Server.TrustProxyHeaders = v != "" && v != "false" && v != "0"
+	}
+	envString("DOCKER_HOST", &cfg.Docker.Host)
+	envString("DOCKER_API_VERSION", &cfg.Docker.APIVersion)
+	envString("DOCKER_CERT_PATH", &cfg.Docker.CertPath)
+	if _, ok := os.LookupEnv("DOCKER_TLS_VERIFY"); ok {
+		cfg.Docker.TLSVerify = os.Getenv("DOCKER_TLS_VERIFY") != ""
+	}
+	if mode := os.Getenv("CONMAN_AUTH"); mode != "" {
+		cfg.Auth.Mode = strings.ToLower(mode)
+	}
+	envString("CONMAN_AUTH_HTTP_HEADER", &cfg.Auth.HTTPHeader)
+	for env, role := range map[string]string{"CONMAN_AUTH_ADMINS": RoleAdmin, "CONMAN_AUTH_OPERATORS": RoleOperator, "CONMAN_AUTH_FILES_USERS": RoleFiles} {
+		if subjects, ok := os.LookupEnv(env); ok {
+			cfg.Auth.Roles[role] = splitList(subjects)
+		}
+	}
+	envString("CONMAN_LABEL_AUTH_ID", &cfg.Labels.AuthID)
+
+	if _, ok := os.LookupEnv("CONMAN_LOG_AUDIT"); ok {
+		cfg.Audit.Stdout = true
+	}
+	envString("CONMAN_AUDIT_FILE", &cfg.Audit.File)
+	if err := envInt("CONMAN_AUDIT_FILE_MAX_SIZE_MB", &cfg.Audit.FileMaxSizeMB); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_AUDIT_FILE_MAX_BACKUPS", &cfg.Audit.FileMaxBackups); err != nil {
+		return err
+	}
+	if addr, ok := os.LookupEnv("CONMAN_AUDIT_SYSLOG"); ok {
+		cfg.Audit.Syslog = true
+		cfg.Audit.SyslogAddress = addr
+	}
+	envString("CONMAN_AUDIT_WEBHOOK", &cfg.Audit.Webhook)
+	envString("CONMAN_TEMPLATES_DIR", &cfg.Templates.Dir)
+	envString("CONMAN_REGISTRIES_FILE", &cfg.Registries.File)
+	envString("CONMAN_REGISTRIES_KEY", &cfg.Registries.Key)
+
+	if v, ok := os.LookupEnv("CONMAN_CONTAINER_METRICS"); ok {
+		cfg.ContainerMetrics.Enabled = v != "" && v != "false" && v != "0"
+	}
+	if err := envDuration("CONMAN_CONTAINER_METRICS_INTERVAL", &cfg.ContainerMetrics.Interval); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_CONTAINER_METRICS_CONCURRENCY", &cfg.ContainerMetrics.Concurrency); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_LOG_SEARCH_CONCURRENCY", &cfg.Logs.SearchConcurrency); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_FILES_MAX_DOWNLOAD_MB", &cfg.Files.MaxDownloadMB); err != nil {
+		return err
+	}
+	if err := envInt("CONMAN_FILES_MAX_UPLOAD_MB", &cfg.Files.MaxUploadMB); err != nil {
+		return err
+	}
+	if v, ok := os.LookupEnv("CONMAN_IMAGE_UPDATES"); ok {
+		cfg.ImageUpdates.Enabled = v != "" && v != "false" && v != "0"
+	}
+	if err := envDuration("CONMAN_IMAGE_UPDATES_INTERVAL", &cfg.ImageUpdates.Interval); err != nil {
+		return err
+	}
+	if v, ok := os.LookupEnv("CONMAN_IMAGE_UPDATES_INSECURE_REGISTRIES"); ok {
+		cfg.ImageUpdates.InsecureRegistries = splitList(v)
+	}
+	return nil
+}
+
+func envInt(key string, v *int) error {
+	s := strings.TrimSpace(os.Getenv(key))
+	if s == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("environment variable %s: %w", key, err)
+	}
+	*v = i
+	return nil
+}
+
+func envDuration(key string, v *Duration) error {
+	s := strings.TrimSpace(os.Getenv(key))
+	if s == "" {
+		return nil
+	}
+	if err := v.set(s); err != nil {
+		return fmt.Errorf("environment variable %s: %w", key, err)
+	}
+	return nil
+}
+
+// Validate reports the first setting that would keep conman from starting.
+func (cfg Config) Validate() error {
+	if cfg.Listen == "" {
+		return errors.New("listen address is not set")
+	}
+	if cfg.URLRoot != "" && (!strings.HasPrefix(cfg.URLRoot, "/") || strings.HasSuffix(cfg.URLRoot, "/")) {
+		return fmt.Errorf("URL root %q must start with, but not end with, a slash", cfg.URLRoot)
+	}
+	if cfg.StaticDir != "" {
+		if fi, err := os.Stat(cfg.StaticDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("static directory %q is not a directory", cfg.StaticDir)
+		}
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return errors.New("both a TLS certificate and key file must be given")
+	}
+	if cfg.TLS.CertFile == "" && (cfg.TLS.ClientCAFile != "" || cfg.TLS.RedirectListen != "") {
+		return errors.New("client certificates and HTTPS redirect require a TLS certificate")
+	}
+	switch cfg.TLS.ClientAuth {
+	case "", ClientAuthOptional, ClientAuthRequire:
+	default:
+		return fmt.Errorf("unknown TLS client auth %q, use optional or require", cfg.TLS.ClientAuth)
+	}
+	if cfg.Server.ReadHeaderTimeout < 0 || cfg.Server.IdleTimeout < 0 || cfg.Server.RequestTimeout < 0 ||
+		cfg.Server.ShutdownTimeout < 0 || cfg.Server.MaxConcurrentRequests < 0 || cfg.Server.ListCacheTTL < 0 {
+		return errors.New("server timeouts and request limit can not be negative")
+	}
+	if cfg.Docker.Host == "" {
+		return errors.New("Docker host is not set")
+	}
+	if _, _, _, err := client.ParseHost(cfg.Docker.Host); err != nil {
+		return fmt.Errorf("Docker host %q: %w", cfg.Docker.Host, err)
+	}
+	switch cfg.Auth.Mode {
+	case AuthModeNone:
+	case AuthModeHTTP:
+		if cfg.Auth.HTTPHeader == "" {
+			return errors.New("authentication mode is http but no HTTP header is set")
+		}
+	case AuthModeTLS:
+		if cfg.TLS.ClientCAFile == "" {
+			return errors.New("authentication mode is tls but no client CA file is set")
+		}
+	default:
+		return fmt.Errorf("unknown authentication mode %q", cfg.Auth.Mode)
+	}
+	if cfg.Labels.AuthID == "" {
+		return errors.New("auth ID label key is not set")
+	}
+	if cfg.ContainerMetrics.Enabled && (cfg.ContainerMetrics.Interval <= 0 || cfg.ContainerMetrics.Concurrency < 1) {
+		return errors.New("container metrics need a positive interval and concurrency")
+	}
+	if cfg.ImageUpdates.Enabled && cfg.ImageUpdates.Interval <= 0 {
+		return errors.New("image update checks need a positive interval")
+	}
+	if cfg.Logs.SearchConcurrency < 1 {
+		return errors.New("log search concurrency must be at least 1")
+	}
+	if cfg.Files.MaxDownloadMB < 1 || cfg.Files.MaxUploadMB < 1 {
+		return errors.New("container file download and upload limits must be at least 1 MB")
+	}
+	if cfg.Registries.File != "" && cfg.Registries.Key == "" {
+		return errors.New("the registry credentials file needs a key")
+	}
+	if err := cfg.ContainerCreate.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Alerts.Validate(); err != nil {
+		return err
+	}
+	if err := validateWebhooks(cfg.Webhooks); err != nil {
+		return err
+	}
+	if cfg.Audit.FileMaxSizeMB < 0 || cfg.Audit.FileMaxBackups < 0 {
+		return errors.New("audit file size and backups can not be negative")
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
-	"io/ioutil"
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -15,14 +22,106 @@ type hateoasLink struct {
 	Type string `json:"type,omitempty"`
 }
 
-func errLogWrapper(errLog, auditLog *log.Logger, fn func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
+// Linker builds HATEOAS links from the named routes of the router, so they
+// include the URL root and, when TrustProxy is set, any prefix and host
+// added by a reverse proxy.
+type Linker struct {
+	Router     *mux.Router
+	TrustProxy bool
+}
+
+func (l Linker) Link(r *http.Request, route, rel, method string, pairs ...string) *hateoasLink {
+	rt := l.Router.Get(route)
+	if rt == nil {
+		return nil
+	}
+	u, err := rt.URL(pairs...)
+	if err != nil {
+		return nil
+	}
+	href := u.Path
+	if !l.TrustProxy {
+		return &hateoasLink{Href: href, Rel: rel, Type: method}
+	}
+	if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" {
+		href = strings.TrimSuffix(prefix, "/") + href
+	}
+	if host := firstHeaderValue(r, "X-Forwarded-Host"); host != "" {
+		proto := firstHeaderValue(r, "X-Forwarded-Proto")
+		if proto == "" {
+			proto = "http"
+			if r.TLS != nil {
+				proto = "https"
+			}
+		}
+		href = proto + "://" + host + href
+	}
+	return &hateoasLink{Href: href, Rel: rel, Type: method}
+}
+
+// firstHeaderValue returns the first entry of a comma separated header, as
+// set by a chain of proxies.
+func firstHeaderValue(r *http.Request, key string) string {
+	v := r.Header.Get(key)
+	if i := strings.Index(v, ","); i > -1 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+func errLogWrapper(errLog *log.Logger, fn func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auditLog.Printf("%s %s %s %s", r.RemoteAddr, r.Method, r.URL, r.UserAgent())
-		err := fn(w, r)
-		if err != nil {
-			errLog.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		rid := r.Header.Get("X-Request-ID")
+		if rid == "" {
+			rid = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", rid)
+		r = withRequestID(r, rid)
+
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		err := fn(sr, r)
+		if err != nil && sr.started {
+			// Streamed responses, such as zip archives, would be corrupted by
+			// an error body.
+			errLog.Printf("%s %s %s: response cut off: %v", rid, r.Method, r.URL, err)
+		} else if err != nil {
+			ae := classifyError(err)
+			if ae.Status >= http.StatusInternalServerError {
+				errLog.Printf("%s %s %s: %v", rid, r.Method, r.URL, err)
+			}
+			writeError(sr, r, ae)
+		}
+		route := ""
+		if cr := mux.CurrentRoute(r); cr != nil {
+			route, _ = cr.GetPathTemplate()
 		}
+		metrics.HTTPRequests.Inc(route, r.Method, strconv.Itoa(sr.status))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// started is set once the response has been started.
+	started bool
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.started = true
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.started = true
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
 }
 
@@ -35,8 +134,7 @@ func authContainerWrapper(auth Authenticator, fn func(containerID string, w http
 			return err
 		}
 		if !allowed {
-			w.WriteHeader(http.StatusForbidden)
-			return nil
+			return errForbidden
 		}
 		return fn(containerID, w, r)
 	}
@@ -51,47 +149,213 @@ func authServiceWrapper(auth Authenticator, fn func(serviceID string, w http.Res
 			return err
 		}
 		if !allowed {
-			w.WriteHeader(http.StatusForbidden)
-			return nil
+			return errForbidden
 		}
 		return fn(serviceID, w, r)
 	}
 }
 
-func main() {
-	router := mux.NewRouter()
+// queryLimit returns the limit query parameter, or def when not given.
+func queryLimit(r *http.Request, def int) (int, error) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return def, nil
+	}
+	limit, err := strconv.Atoi(l)
+	if err != nil {
+		return 0, newBadRequestError(fmt.Sprintf("invalid limit %q", l))
+	}
+	return limit, nil
+}
 
-	var auth Authenticator
-	if os.Getenv("CONMAN_AUTH") == "HTTP" {
-		header := os.Getenv("CONMAN_AUTH_HTTP_HEADER")
-		if header == "" {
-			log.Fatalln("Environment variable CONMAN_AUTH is set to HTTP but the variable CONMAN_AUTH_HTTP_HEADER is not set")
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
 		}
-		auth = HTTPHeaderAuthenticator{HTTPHeader: header, ContainerLabelKey: "conman.auth.id"}
-	} else {
-		auth = NoOpAuthenticator{}
 	}
+	return list
+}
 
-	urlRoot, rootSet := os.LookupEnv("CONMAN_URL_ROOT")
-	if !rootSet {
-		urlRoot = ""
+func NewAuthenticator(cfg Config) Authenticator {
+	switch cfg.Auth.Mode {
+	case AuthModeHTTP:
+		return HTTPHeaderAuthenticator{
+			HTTPHeader:        cfg.Auth.HTTPHeader,
+			ContainerLabelKey: cfg.Labels.AuthID,
+			Roles:             cfg.Auth.Roles,
+		}
+	case AuthModeTLS:
+		return ClientCertAuthenticator{
+			ContainerLabelKey: cfg.Labels.AuthID,
+			Roles:             cfg.Auth.Roles,
+		}
 	}
+	return NoOpAuthenticator{}
+}
 
-	auditLog := log.New(ioutil.Discard, "AUDIT ", log.LstdFlags)
-	_, auditEnv := os.LookupEnv("CONMAN_LOG_AUDIT")
-	if auditEnv {
-		auditLog.SetOutput(os.Stdout)
+func main() {
+	cfg, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		log.Fatalln(err)
 	}
+	dockerConfig = cfg.Docker
+	listCache = newResponseCache(time.Duration(cfg.Server.ListCacheTTL))
+	auth := NewAuthenticator(cfg)
+	urlRoot := cfg.URLRoot
+
 	errLog := log.New(os.Stdout, "ERROR ", log.LstdFlags)
+	auditSinks, err := NewAuditSinks(cfg.Audit)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	webhooks := NewWebhookDispatcher(cfg.Webhooks, errLog)
+	audit := NewAuditLog(errLog, append(auditSinks, webhooks)...)
+	defer audit.Close()
 
+	if cfg.Registries.File != "" {
+		registries, err = NewRegistryStore(cfg.Registries.File, cfg.Registries.Key)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	timeout := time.Duration(cfg.Server.RequestTimeout)
+	router := mux.NewRouter()
+	router.HandleFunc(urlRoot+"/healthz", Healthz).Methods("GET")
+	router.HandleFunc(urlRoot+"/readyz", errLogWrapper(errLog, Readyz)).Methods("GET")
+	router.Handle(urlRoot+"/metrics", metricsAuth(auth, cfg.Server.MetricsToken, metrics)).Methods("GET")
+	metrics.Register(collectContainerStates)
 	apiRouter := router.PathPrefix(urlRoot + "/api").Subrouter()
-	apiRouter.HandleFunc("/containers", errLogWrapper(errLog, auditLog, ListContainers(auth)))
-	apiRouter.HandleFunc("/containers/{id}/log/download", errLogWrapper(errLog, auditLog, authContainerWrapper(auth, DownloadContainerLog))).Methods("GET")
-	apiRouter.HandleFunc("/containers/{id}", errLogWrapper(errLog, auditLog, authContainerWrapper(auth, RemoveContainer))).Methods("DELETE")
-	apiRouter.HandleFunc("/services", errLogWrapper(errLog, auditLog, ListServices(auth)))
-	apiRouter.HandleFunc("/services/{id}/log/download", errLogWrapper(errLog, auditLog, authServiceWrapper(auth, DownloadServiceLog))).Methods("GET")
+	links := Linker{Router: router, TrustProxy: cfg.Server.TrustProxyHeaders}
+	apiRouter.Handle("/containers", withTimeout(timeout, errLogWrapper(errLog, cachedList(auth, ListContainers(auth, links))))).Methods("GET").Name(RouteContainerList)
+	// Creating may pull the image, which can take longer than the request
+	// timeout.
+	apiRouter.HandleFunc("/containers", errLogWrapper(errLog, auditWrapper(audit, auth, "container.create", "container", CreateContainer(auth, cfg.ContainerCreate, cfg.Labels.AuthID, links)))).Methods("POST")
+	templates := TemplateStore{Dir: cfg.Templates.Dir, ErrLog: errLog}
+	apiRouter.Handle("/templates", withTimeout(timeout, errLogWrapper(errLog, ListTemplates(auth, templates, links)))).Methods("GET")
+	apiRouter.HandleFunc("/templates/{name}", errLogWrapper(errLog, auditWrapper(audit, auth, "container.create", "container", InstantiateTemplate(auth, templates, cfg.ContainerCreate, cfg.Labels.AuthID, links)))).Methods("POST").Name(RouteTemplateInstantiate)
+	apiRouter.HandleFunc("/containers/{id}/log/download", errLogWrapper(errLog, authContainerWrapper(auth, DownloadContainerLog))).Methods("GET").Name(RouteContainerLogDownload)
+	apiRouter.Handle("/containers/{id}", withTimeout(timeout, errLogWrapper(errLog, auditWrapper(audit, auth, "container.remove", "container", authContainerWrapper(auth, RemoveContainer))))).Methods("DELETE").Name(RouteContainerRemove)
+	// Recreating pulls the image and waits for the new container to become
+	// healthy.
+	apiRouter.HandleFunc("/containers/{id}/recreate", errLogWrapper(errLog, auditWrapper(audit, auth, "container.recreate", "container", authContainerWrapper(auth, RecreateContainer(auth, links))))).Methods("POST").Name(RouteContainerRecreate)
+	apiRouter.Handle("/containers/{id}/files", withTimeout(timeout, errLogWrapper(errLog, authContainerWrapper(auth, ListContainerFiles(auth, links))))).Methods("GET").Name(RouteContainerFiles)
+	apiRouter.HandleFunc("/containers/{id}/files/download", errLogWrapper(errLog, auditWrapper(audit, auth, "container.files.download", "container", authContainerWrapper(auth, DownloadContainerFiles(auth, int64(cfg.Files.MaxDownloadMB)<<20))))).Methods("GET").Name(RouteContainerFileDownload)
+	apiRouter.HandleFunc("/containers/{id}/files", errLogWrapper(errLog, auditWrapper(audit, auth, "container.files.upload", "container", authContainerWrapper(auth, UploadContainerFiles(auth, int64(cfg.Files.MaxUploadMB)<<20))))).Methods("POST")
+	apiRouter.Handle("/containers/{id}/diff", withTimeout(timeout, errLogWrapper(errLog, authContainerWrapper(auth, DiffContainer(auth, links))))).Methods("GET").Name(RouteContainerDiff)
+	apiRouter.HandleFunc("/containers/{id}/diff/export", errLogWrapper(errLog, auditWrapper(audit, auth, "container.diff.export", "container", authContainerWrapper(auth, ExportContainerDiff(auth, int64(cfg.Files.MaxDownloadMB)<<20))))).Methods("GET").Name(RouteContainerDiffExport)
+	apiRouter.Handle("/containers/{id}/top", withTimeout(timeout, errLogWrapper(errLog, authContainerWrapper(auth, TopContainer(auth, links))))).Methods("GET").Name(RouteContainerTop)
+	apiRouter.Handle("/containers/{id}/processes/{pid}/signal", withTimeout(timeout, errLogWrapper(errLog, auditWrapper(audit, auth, "container.process.signal", "container", authContainerWrapper(auth, SignalContainerProcess(auth)))))).Methods("POST").Name(RouteContainerProcessSignal)
+	apiRouter.Handle("/services", withTimeout(timeout, errLogWrapper(errLog, cachedList(auth, ListServices(auth, links))))).Methods("GET").Name(RouteServiceList)
+	apiRouter.HandleFunc("/services/{id}/log/download", errLogWrapper(errLog, authServiceWrapper(auth, DownloadServiceLog))).Methods("GET").Name(RouteServiceLogDownload)
+	apiRouter.Handle("/services/{id}/update", withTimeout(timeout, errLogWrapper(errLog, auditWrapper(audit, auth, "service.update", "service", authServiceWrapper(auth, UpdateService(auth)))))).Methods("POST").Name(RouteServiceUpdate)
+	apiRouter.Handle("/registries", withTimeout(timeout, errLogWrapper(errLog, ListRegistries(auth, links)))).Methods("GET").Name(RouteRegistryList)
+	apiRouter.Handle("/registries/{id}", withTimeout(timeout, errLogWrapper(errLog, auditWrapper(audit, auth, "registry.update", "registry", PutRegistry(auth))))).Methods("PUT")
+	apiRouter.Handle("/registries/{id}", withTimeout(timeout, errLogWrapper(errLog, auditWrapper(audit, auth, "registry.remove", "registry", RemoveRegistry(auth))))).Methods("DELETE").Name(RouteRegistryRemove)
+	apiRouter.HandleFunc("/logs/search", errLogWrapper(errLog, SearchLogs(auth, cfg.Logs.SearchConcurrency))).Methods("GET")
+	apiRouter.HandleFunc("/logs/follow", errLogWrapper(errLog, FollowLogs(auth))).Methods("GET")
+	apiRouter.HandleFunc("/logs/export", errLogWrapper(errLog, ExportLogs(auth))).Methods("POST")
+	apiRouter.Handle("/audit", withTimeout(timeout, errLogWrapper(errLog, ListAuditRecords(auth, audit)))).Methods("GET")
+	apiRouter.Handle("/webhooks/deliveries", withTimeout(timeout, errLogWrapper(errLog, ListWebhookDeliveries(auth, webhooks)))).Methods("GET")
 
-	router.PathPrefix(urlRoot + "/").Handler(http.StripPrefix(urlRoot, http.FileServer(http.Dir("/www"))))
+	static, err := NewStaticHandler(cfg.StaticDir, urlRoot)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	router.PathPrefix(urlRoot + "/").Handler(http.StripPrefix(urlRoot, static))
 	router.PathPrefix(urlRoot).Handler(http.RedirectHandler(urlRoot+"/", http.StatusMovedPermanently))
-	http.ListenAndServe(":8080", router)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	if cfg.ContainerMetrics.Enabled {
+		csc := &ContainerStatsCollector{
+			Interval:    time.Duration(cfg.ContainerMetrics.Interval),
+			Concurrency: cfg.ContainerMetrics.Concurrency,
+			LabelKey:    cfg.Labels.AuthID,
+			ErrLog:      errLog,
+		}
+		metrics.Register(csc.Collect)
+		go csc.Run(ctx)
+	}
+	if cfg.ImageUpdates.Enabled {
+		imageUpdates = &ImageUpdateChecker{
+			Interval:           time.Duration(cfg.ImageUpdates.Interval),
+			InsecureRegistries: cfg.ImageUpdates.InsecureRegistries,
+			Client:             &http.Client{Timeout: 30 * time.Second},
+			ErrLog:             errLog,
+		}
+		go imageUpdates.Run(ctx)
+	}
+	if len(cfg.Alerts.Rules) > 0 {
+		go NewAlerter(cfg.Alerts, errLog).Run(ctx)
+	}
+
+	// No write timeout is set since log downloads stream for as long as the
+	// client reads, the other routes are bounded by withTimeout instead.
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           limitConcurrency(cfg.Server.MaxConcurrentRequests, router),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+	}
+	servers := []*http.Server{server}
+	listen := server.ListenAndServe
+
+	if cfg.TLS.CertFile != "" {
+		certs, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, errLog)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go certs.watch(ctx, certReloadInterval)
+		server.TLSConfig, err = NewServerTLSConfig(cfg.TLS, certs)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		listen = func() error { return server.ListenAndServeTLS("", "") }
+
+		if cfg.TLS.RedirectListen != "" {
+			redirect := &http.Server{
+				Addr:              cfg.TLS.RedirectListen,
+				Handler:           httpsRedirectHandler(cfg.Listen),
+				ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+				WriteTimeout:      timeout,
+				IdleTimeout:       time.Duration(cfg.Server.IdleTimeout),
+			}
+			servers = append(servers, redirect)
+			go func() {
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					errLog.Println(err)
+				}
+			}()
+		}
+	}
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		log.Println("shutting down")
+		streams.Close()
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+		defer cancel()
+		for _, s := range servers {
+			if err := s.Shutdown(sctx); err != nil {
+				errLog.Println(err)
+			}
+		}
+	}()
+
+	if err := listen(); err != http.ErrServerClosed {
+		errLog.Println(err)
+		return
+	}
+	<-shutdown
 }
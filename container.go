@@ -1,104 +1,233 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/filters"
 )
 
 type ContainerLinks struct {
 	DownloadLog *hateoasLink `json:"downloadLog,omitempty"`
 	Remove      *hateoasLink `json:"remove,omitempty"`
+	Recreate    *hateoasLink `json:"recreate,omitempty"`
+	Files       *hateoasLink `json:"files,omitempty"`
+	Diff        *hateoasLink `json:"diff,omitempty"`
+	Top         *hateoasLink `json:"top,omitempty"`
 }
 
-func NewDownloadContainerLogLink(id string) *hateoasLink {
-	return &hateoasLink{Href: fmt.Sprintf("/api/containers/%s/log/download", id), Rel: "downloadLog", Type: "GET"}
+const (
+	RouteContainerList        = "containerList"
+	RouteContainerLogDownload = "containerLogDownload"
+	RouteContainerRemove      = "containerRemove"
+)
+
+func NewDownloadContainerLogLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerLogDownload, "downloadLog", "GET", "id", id)
 }
 
-func NewRemoveContainerLink(id string) *hateoasLink {
-	return &hateoasLink{Href: fmt.Sprintf("/api/containers/%s", id), Rel: "remove", Type: "DELETE"}
+func NewRemoveContainerLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerRemove, "remove", "DELETE", "id", id)
 }
 
 type Container struct {
-	ID     string         `json:"id"`
-	Name   string         `json:"name"`
-	Image  string         `json:"image"`
-	Status string         `json:"status"`
-	State  string         `json:"state"`
-	Links  ContainerLinks `json:"links"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Status string `json:"status"`
+	State  string `json:"state"`
+	// UpdateAvailable is set when image update checks are enabled and the
+	// registry has a newer image for the tag of the container.
+	UpdateAvailable bool           `json:"updateAvailable"`
+	Links           ContainerLinks `json:"links"`
+}
+
+var containerSortKeys = []string{"name", "image", "state", "created"}
+
+// containerFilters turns the name, state and label query parameters into
+// Docker list filters.
+func containerFilters(r *http.Request) filters.Args {
+	q := r.URL.Query()
+	f := filters.NewArgs()
+	for param, filter := range map[string]string{"name": "name", "state": "status", "label": "label"} {
+		for _, v := range q[param] {
+			f.Add(filter, v)
+		}
+	}
+	return f
 }
 
-func ListContainers(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+func containerSortKey(c types.Container, key string) string {
+	switch key {
+	case "name":
+		return containerName(c)
+	case "image":
+		return c.Image
+	case "state":
+		return c.State
+	}
+	return unixSortKey(c.Created)
+}
+
+func containerName(c types.Container) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
+
+// listAuthConcurrency bounds the authorization lookups, each inspecting a
+// container, made at once when listing.
+const listAuthConcurrency = 8
+
+func ListContainers(auth Authenticator, links Linker) func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		cli, err := client.NewEnvClient()
+		opts, err := parseListOptions(r, containerSortKeys, "-created")
 		if err != nil {
 			return err
 		}
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		ctx := r.Context()
 
-		cs, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+		cs, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: containerFilters(r)})
 		if err != nil {
 			return err
 		}
+		tags, err := imageTags(ctx)
+		if err != nil {
+			return err
+		}
+		// Docker's ancestor filter only takes whole references, images are
+		// matched on part of the name as for services.
+		if image := r.URL.Query().Get("image"); image != "" {
+			matched := cs[:0]
+			for _, c := range cs {
+				if strings.Contains(containerImage(c, tags), image) {
+					matched = append(matched, c)
+				}
+			}
+			cs = matched
+		}
 
-		containers := []Container{}
-		for _, c := range cs {
-			allowed, err := auth.IsContainerAllowed(r, c.ID)
-			if err != nil {
-				return err
+		allowed := make([]bool, len(cs))
+		errs := make([]error, len(cs))
+		sem := make(chan struct{}, listAuthConcurrency)
+		var wg sync.WaitGroup
+		for i, c := range cs {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, id string) {
+				defer func() { <-sem; wg.Done() }()
+				allowed[i], errs[i] = auth.IsContainerAllowed(r, id)
+			}(i, c.ID)
+		}
+		wg.Wait()
+
+		byID := map[string]types.Container{}
+		items := []listItem{}
+		for i, c := range cs {
+			if errs[i] != nil {
+				if classifyError(errs[i]).Status == http.StatusNotFound {
+					// Removed since it was listed.
+					continue
+				}
+				return errs[i]
 			}
-			if !allowed {
+			if !allowed[i] {
 				continue
 			}
-			ci, err := cli.ContainerInspect(context.Background(), c.ID)
-			if err != nil {
-				return err
-			}
-			image, _, err := cli.ImageInspectWithRaw(context.Background(), ci.Image)
-			if err != nil {
-				return err
-			}
-			container := Container{ID: ci.ID, Name: ci.Name[1:], State: c.State, Status: c.Status}
-			if len(image.RepoTags) > 0 {
-				container.Image = image.RepoTags[0]
-			}
+			byID[c.ID] = c
+			items = append(items, listItem{ID: c.ID, Key: containerSortKey(c, opts.sortKey)})
+		}
+		page, next := opts.page(items)
+
+		containers := []Container{}
+		for _, item := range page {
+			c := byID[item.ID]
+			container := Container{ID: c.ID, Name: containerName(c), Image: containerImage(c, tags), State: c.State, Status: c.Status, UpdateAvailable: imageUpdates.UpdateAvailable(c.ID)}
 			switch c.State {
 			case "exited":
-				container.Links.Remove = NewRemoveContainerLink(container.ID)
+				container.Links.Remove = NewRemoveContainerLink(links, r, container.ID)
+			case "running":
+				container.Links.Top = NewContainerTopLink(links, r, container.ID)
+			}
+			container.Links.DownloadLog = NewDownloadContainerLogLink(links, r, container.ID)
+			if auth.HasRole(r, RoleOperator) {
+				container.Links.Recreate = NewRecreateContainerLink(links, r, container.ID)
+			}
+			container.Links.Diff = NewContainerDiffLink(links, r, container.ID)
+			if auth.HasRole(r, RoleFiles) {
+				container.Links.Files = NewContainerFilesLink(links, r, container.ID)
 			}
-			container.Links.DownloadLog = NewDownloadContainerLogLink(container.ID)
 			containers = append(containers, container)
 		}
 		b, err := json.Marshal(containers)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
+		setNextLink(w, r, links, RouteContainerList, next)
 		w.Header().Set("Content-type", "application/json")
 		fmt.Fprint(w, string(b))
 		return nil
 	}
 }
 
+// containerImage is the tag of the image of the container, or the image it
+// was created from when the image has no tag.
+func containerImage(c types.Container, tags map[string]string) string {
+	if tag, ok := tags[c.ImageID]; ok {
+		return tag
+	}
+	return c.Image
+}
+
+// imageTags maps image IDs to their first tag, with a single call instead of
+// inspecting the image of every container.
+func imageTags(ctx context.Context) (map[string]string, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	images, err := cli.ImageList(ctx, types.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag != "<none>:<none>" {
+				tags[img.ID] = tag
+				break
+			}
+		}
+	}
+	return tags, nil
+}
+
 func RemoveContainer(containerID string, w http.ResponseWriter, r *http.Request) error {
-	client, _ := client.NewEnvClient()
-	err := client.ContainerRemove(context.Background(), containerID, types.ContainerRemoveOptions{})
+	client, _ := newDockerClient()
+	err := client.ContainerRemove(r.Context(), containerID, types.ContainerRemoveOptions{})
 	if err != nil {
 		return err
 	}
+	listCache.Invalidate()
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
 func StopContainer(containerID string, w http.ResponseWriter, r *http.Request) error {
-	client, _ := client.NewEnvClient()
+	client, _ := newDockerClient()
 	d := 5 * time.Second
-	err := client.ContainerStop(context.Background(), containerID, &d)
+	err := client.ContainerStop(r.Context(), containerID, &d)
 	if err != nil {
 		return err
 	}
@@ -107,31 +236,13 @@ func StopContainer(containerID string, w http.ResponseWriter, r *http.Request) e
 }
 
 func DownloadContainerLog(containerID string, w http.ResponseWriter, r *http.Request) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	client, _ := client.NewEnvClient()
-	cjson, err := client.ContainerInspect(context.Background(), containerID)
-	if err != nil {
-		return err
-	}
-
-	w.Header().Set("Content-type", "text/plain;charset=UTF-8")
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+cjson.Name+"\"")
+	ctx, done := streams.Context(r)
+	defer done()
 
-	reader, err := client.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: false, Details: false, Timestamps: false})
+	client, _ := newDockerClient()
+	cjson, err := client.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return err
 	}
-
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		line := scanner.Text()[8:] + "\n"
-		w.Write([]byte(line))
-	}
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-
-	return nil
+	return downloadLog(ctx, logTarget{Type: logTargetContainer, ID: cjson.ID, Name: cjson.Name[1:], TTY: cjson.Config != nil && cjson.Config.Tty}, w, r)
 }
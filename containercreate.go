@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// ContainerCreateConfig is the policy containers created through conman
+// must follow.
+type ContainerCreateConfig struct {
+	// Images are patterns, as in path.Match, of the images containers may be
+	// created from. Creating containers is disabled when empty.
+	Images []string `json:"images" yaml:"images"`
+	// BindMounts are the host directories that may be bind mounted, bind
+	// mounts are forbidden when empty.
+	BindMounts []string `json:"bindMounts" yaml:"bindMounts"`
+	// Networks the containers may join, any network but host when empty.
+	// The none and container:<name> network modes must be listed to be used.
+	Networks         []string `json:"networks" yaml:"networks"`
+	AllowHostNetwork bool     `json:"allowHostNetwork" yaml:"allowHostNetwork"`
+	AllowPrivileged  bool     `json:"allowPrivileged" yaml:"allowPrivileged"`
+}
+
+func (ccc ContainerCreateConfig) Validate() error {
+	for _, p := range ccc.Images {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("container create image pattern %q: %w", p, err)
+		}
+	}
+	for _, dir := range ccc.BindMounts {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("container create bind mount %q is not an absolute path", dir)
+		}
+	}
+	return nil
+}
+
+func (ccc ContainerCreateConfig) imageAllowed(image string) bool {
+	image = normalizeImageRef(image)
+	for _, p := range ccc.Images {
+		if ok, _ := path.Match(p, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (ccc ContainerCreateConfig) bindAllowed(source string) bool {
+	source = filepath.Clean(source)
+	for _, dir := range ccc.BindMounts {
+		dir = filepath.Clean(dir)
+		if source == dir || strings.HasPrefix(source, dir+string(filepath.Separator)) || dir == string(filepath.Separator) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ccc ContainerCreateConfig) networkAllowed(name string) bool {
+	switch {
+	case name == "host" && !ccc.AllowHostNetwork:
+		return false
+	case name == "none", strings.HasPrefix(name, "container:"):
+		return containsString(ccc.Networks, name)
+	}
+	return len(ccc.Networks) == 0 || containsString(ccc.Networks, name)
+}
+
+// checkNetworks makes sure the networks exist. They are checked against the
+// policy again by their name, so built-in networks can not be joined by ID.
+func checkNetworks(ctx context.Context, policy ContainerCreateConfig, names []string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if strings.HasPrefix(n, "container:") {
+			continue
+		}
+		nr, err := cli.NetworkInspect(ctx, n)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				return newBadRequestError(fmt.Sprintf("network %s does not exist", n))
+			}
+			return err
+		}
+		if !policy.networkAllowed(nr.Name) {
+			return newForbiddenError(fmt.Sprintf("network %s is not allowed", n))
+		}
+	}
+	return nil
+}
+
+type ContainerMountRequest struct {
+	// Type is volume, bind or tmpfs.
+	Type     string `json:"type" yaml:"type"`
+	Source   string `json:"source" yaml:"source"`
+	Target   string `json:"target" yaml:"target"`
+	ReadOnly bool   `json:"readOnly" yaml:"readOnly"`
+}
+
+// ContainerCreateRequest describes a container to create and start.
+type ContainerCreateRequest struct {
+	Name  string   `json:"name" yaml:"name"`
+	Image string   `json:"image" yaml:"image"`
+	Cmd   []string `json:"cmd" yaml:"cmd"`
+	Env   []string `json:"env" yaml:"env"`
+	// Ports are published as by docker run -p, such as 8080:80/tcp.
+	Ports    []string                `json:"ports" yaml:"ports"`
+	Mounts   []ContainerMountRequest `json:"mounts" yaml:"mounts"`
+	Networks []string                `json:"networks" yaml:"networks"`
+	// RestartPolicy is no, always, unless-stopped or on-failure[:retries].
+	RestartPolicy string            `json:"restartPolicy" yaml:"restartPolicy"`
+	Labels        map[string]string `json:"labels" yaml:"labels"`
+	Privileged    bool              `json:"privileged" yaml:"privileged"`
+}
+
+type containerSpec struct {
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networking *network.NetworkingConfig
+	// extraNetworks are connected after creation, the API only takes one
+	// network when creating a container.
+	extraNetworks []string
+}
+
+// spec checks the request against the policy and builds the Docker
+// configuration.
+func (req ContainerCreateRequest) spec(policy ContainerCreateConfig) (containerSpec, error) {
+	s := containerSpec{}
+	if req.Image == "" {
+		return s, newBadRequestError("image is required")
+	}
+	if !policy.imageAllowed(req.Image) {
+		return s, newForbiddenError(fmt.Sprintf("image %s is not allowed", req.Image))
+	}
+	if req.Privileged && !policy.AllowPrivileged {
+		return s, newForbiddenError("privileged containers are not allowed")
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(req.Ports)
+	if err != nil {
+		return s, newBadRequestError(fmt.Sprintf("invalid ports: %v", err))
+	}
+	restart, err := parseRestartPolicy(req.RestartPolicy)
+	if err != nil {
+		return s, err
+	}
+
+	s.config = &container.Config{
+		Image:        req.Image,
+		Cmd:          req.Cmd,
+		Env:          req.Env,
+		ExposedPorts: exposed,
+		Labels:       map[string]string{},
+	}
+	for k, v := range req.Labels {
+		s.config.Labels[k] = v
+	}
+	s.hostConfig = &container.HostConfig{
+		PortBindings:  bindings,
+		RestartPolicy: restart,
+		Privileged:    req.Privileged,
+	}
+	for _, m := range req.Mounts {
+		if !path.IsAbs(m.Target) {
+			return s, newBadRequestError(fmt.Sprintf("mount target %q is not an absolute path", m.Target))
+		}
+		switch mount.Type(m.Type) {
+		case mount.TypeVolume, mount.TypeTmpfs:
+		case mount.TypeBind:
+			if !filepath.IsAbs(m.Source) || !policy.bindAllowed(m.Source) {
+				return s, newForbiddenError(fmt.Sprintf("bind mounting %s is not allowed", m.Source))
+			}
+		default:
+			return s, newBadRequestError(fmt.Sprintf("unknown mount type %q, use volume, bind or tmpfs", m.Type))
+		}
+		s.hostConfig.Mounts = append(s.hostConfig.Mounts, mount.Mount{Type: mount.Type(m.Type), Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly})
+	}
+	for i, n := range req.Networks {
+		if !policy.networkAllowed(n) {
+			return s, newForbiddenError(fmt.Sprintf("network %s is not allowed", n))
+		}
+		if i == 0 {
+			s.hostConfig.NetworkMode = container.NetworkMode(n)
+			s.networking = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{n: {}}}
+		} else {
+			s.extraNetworks = append(s.extraNetworks, n)
+		}
+	}
+	if len(req.Networks) == 0 && !policy.networkAllowed("bridge") {
+		return s, newForbiddenError("the default network is not allowed, give a network")
+	}
+	return s, nil
+}
+
+func parseRestartPolicy(p string) (container.RestartPolicy, error) {
+	name, retries := p, ""
+	if i := strings.IndexByte(p, ':'); i >= 0 {
+		name, retries = p[:i], p[i+1:]
+	}
+	rp := container.RestartPolicy{Name: name}
+	switch name {
+	case "", "no", "always", "unless-stopped":
+		if retries != "" {
+			return rp, newBadRequestError("only the on-failure restart policy takes a retry count")
+		}
+	case "on-failure":
+		if retries != "" {
+			n, err := strconv.Atoi(retries)
+			if err != nil || n < 0 {
+				return rp, newBadRequestError(fmt.Sprintf("invalid restart retry count %q", retries))
+			}
+			rp.MaximumRetryCount = n
+		}
+	default:
+		return rp, newBadRequestError(fmt.Sprintf("unknown restart policy %q", p))
+	}
+	return rp, nil
+}
+
+// CreateContainer creates and starts a container, pulling its image when
+// missing.
+func CreateContainer(auth Authenticator, policy ContainerCreateConfig, authLabel string, links Linker) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if auth.Subject(r) == "" && !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		var req ContainerCreateRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return newBadRequestError(fmt.Sprintf("invalid container: %v", err))
+		}
+		return respondCreated(w, r, auth, policy, authLabel, links, req)
+	}
+}
+
+// respondCreated creates the container described by req and writes it to the
+// response. The caller's identity is set as the auth label, so they may
+// manage the container afterwards.
+func respondCreated(w http.ResponseWriter, r *http.Request, auth Authenticator, policy ContainerCreateConfig, authLabel string, links Linker, req ContainerCreateRequest) error {
+	spec, err := req.spec(policy)
+	if err != nil {
+		return err
+	}
+	if err := checkNetworks(r.Context(), policy, req.Networks); err != nil {
+		return err
+	}
+	setAuthLabel(spec.config.Labels, authLabel, auth.Subject(r))
+
+	c, err := createContainer(r.Context(), req.Name, spec)
+	if err != nil {
+		return err
+	}
+	setAuditTarget(r, c.ID, c.Name)
+	listCache.Invalidate()
+
+	c.Links.DownloadLog = NewDownloadContainerLogLink(links, r, c.ID)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+	return nil
+}
+
+// setAuthLabel replaces any auth label given in the request with the
+// subject, or drops it for callers without one.
+func setAuthLabel(labels map[string]string, authLabel, subject string) {
+	delete(labels, authLabel)
+	if subject != "" {
+		labels[authLabel] = subject
+	}
+}
+
+// createContainer creates and starts a container from spec. A container
+// that can not be started is removed again.
+func createContainer(ctx context.Context, name string, spec containerSpec) (Container, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return Container{}, err
+	}
+	if err := ensureImage(ctx, spec.config.Image); err != nil {
+		return Container{}, err
+	}
+	created, err := cli.ContainerCreate(ctx, spec.config, spec.hostConfig, spec.networking, name)
+	if err != nil {
+		return Container{}, err
+	}
+	err = func() error {
+		for _, n := range spec.extraNetworks {
+			if err := cli.NetworkConnect(ctx, n, created.ID, nil); err != nil {
+				return err
+			}
+		}
+		return cli.ContainerStart(ctx, created.ID, types.ContainerStartOptions{})
+	}()
+	if err != nil {
+		// The request context may be done, clean up regardless.
+		if rerr := cli.ContainerRemove(context.Background(), created.ID, types.ContainerRemoveOptions{Force: true}); rerr != nil {
+			err = errors.New(err.Error() + ", removing the container failed: " + rerr.Error())
+		}
+		return Container{}, err
+	}
+	ci, err := cli.ContainerInspect(ctx, created.ID)
+	if err != nil {
+		return Container{}, err
+	}
+	return Container{ID: ci.ID, Name: ci.Name[1:], Image: spec.config.Image, State: ci.State.Status}, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestContainerCreateSpecPolicy(t *testing.T) {
+	strict := ContainerCreateConfig{
+		Images:     []string{"nginx:*", "registry.example.com/team/*"},
+		BindMounts: []string{"/srv/data"},
+		Networks:   []string{"frontend", "none"},
+	}
+	open := ContainerCreateConfig{
+		Images:           []string{"*"},
+		AllowHostNetwork: true,
+		AllowPrivileged:  true,
+	}
+	bind := func(source string) []ContainerMountRequest {
+		return []ContainerMountRequest{{Type: "bind", Source: source, Target: "/data"}}
+	}
+	tests := []struct {
+		name   string
+		policy ContainerCreateConfig
+		req    ContainerCreateRequest
+		status int
+	}{
+		{"image without tag", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}}, 0},
+		{"image with tag", strict, ContainerCreateRequest{Image: "nginx:1.25", Networks: []string{"frontend"}}, 0},
+		{"image in registry", strict, ContainerCreateRequest{Image: "registry.example.com/team/app:1", Networks: []string{"frontend"}}, 0},
+		{"image not listed", strict, ContainerCreateRequest{Image: "redis", Networks: []string{"frontend"}}, http.StatusForbidden},
+		{"image in a sub repository", strict, ContainerCreateRequest{Image: "registry.example.com/team/sub/app:1", Networks: []string{"frontend"}}, http.StatusForbidden},
+		{"image missing", strict, ContainerCreateRequest{Networks: []string{"frontend"}}, http.StatusBadRequest},
+		{"no images listed", ContainerCreateConfig{}, ContainerCreateRequest{Image: "nginx"}, http.StatusForbidden},
+
+		{"bind dir", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: bind("/srv/data")}, 0},
+		{"bind below dir", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: bind("/srv/data/www")}, 0},
+		{"bind sibling with prefix", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: bind("/srv/database")}, http.StatusForbidden},
+		{"bind escaping dir", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: bind("/srv/data/../../etc")}, http.StatusForbidden},
+		{"bind relative", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: bind("srv/data")}, http.StatusForbidden},
+		{"bind without dirs listed", open, ContainerCreateRequest{Image: "nginx", Mounts: bind("/srv/data")}, http.StatusForbidden},
+		{"volume", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: []ContainerMountRequest{{Type: "volume", Source: "data", Target: "/data"}}}, 0},
+		{"relative target", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: []ContainerMountRequest{{Type: "volume", Source: "data", Target: "data"}}}, http.StatusBadRequest},
+		{"unknown mount type", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Mounts: []ContainerMountRequest{{Type: "npipe", Source: "x", Target: "/x"}}}, http.StatusBadRequest},
+
+		{"listed network", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend", "none"}}, 0},
+		{"network not listed", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"backend"}}, http.StatusForbidden},
+		{"default network not listed", strict, ContainerCreateRequest{Image: "nginx"}, http.StatusForbidden},
+		{"host network", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"host"}}, http.StatusForbidden},
+		{"container network", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"container:web"}}, http.StatusForbidden},
+		{"host network allowed", open, ContainerCreateRequest{Image: "nginx", Networks: []string{"host"}}, 0},
+		{"default network", open, ContainerCreateRequest{Image: "nginx"}, 0},
+		{"none not listed", open, ContainerCreateRequest{Image: "nginx", Networks: []string{"none"}}, http.StatusForbidden},
+
+		{"privileged", strict, ContainerCreateRequest{Image: "nginx", Networks: []string{"frontend"}, Privileged: true}, http.StatusForbidden},
+		{"privileged allowed", open, ContainerCreateRequest{Image: "nginx", Privileged: true}, 0},
+		{"invalid ports", open, ContainerCreateRequest{Image: "nginx", Ports: []string{"http"}}, http.StatusBadRequest},
+		{"invalid restart policy", open, ContainerCreateRequest{Image: "nginx", RestartPolicy: "always:3"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, err := tt.req.spec(tt.policy)
+		if tt.status == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if ae, ok := err.(*apiError); !ok || ae.Status != tt.status {
+			t.Errorf("%s: got %v, want status %d", tt.name, err, tt.status)
+		}
+	}
+}
+
+func TestContainerCreateSpec(t *testing.T) {
+	policy := ContainerCreateConfig{Images: []string{"nginx:*"}, BindMounts: []string{"/srv"}, Networks: []string{"frontend", "backend"}}
+	req := ContainerCreateRequest{
+		Image:         "nginx",
+		Ports:         []string{"8080:80"},
+		Mounts:        []ContainerMountRequest{{Type: "bind", Source: "/srv/www", Target: "/usr/share/nginx/html", ReadOnly: true}},
+		Networks:      []string{"frontend", "backend"},
+		RestartPolicy: "on-failure:3",
+		Labels:        map[string]string{"team": "web", "conman.auth": "someone-else"},
+	}
+	s, err := req.spec(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.hostConfig.NetworkMode != "frontend" || len(s.extraNetworks) != 1 || s.extraNetworks[0] != "backend" {
+		t.Errorf("networks %q, extra %q", s.hostConfig.NetworkMode, s.extraNetworks)
+	}
+	if len(s.hostConfig.Mounts) != 1 || s.hostConfig.Mounts[0] != (mount.Mount{Type: mount.TypeBind, Source: "/srv/www", Target: "/usr/share/nginx/html", ReadOnly: true}) {
+		t.Errorf("mounts %+v", s.hostConfig.Mounts)
+	}
+	if s.hostConfig.RestartPolicy.Name != "on-failure" || s.hostConfig.RestartPolicy.MaximumRetryCount != 3 {
+		t.Errorf("restart policy %+v", s.hostConfig.RestartPolicy)
+	}
+	if s.hostConfig.Privileged {
+		t.Error("privileged")
+	}
+
+	setAuthLabel(s.config.Labels, "conman.auth", "alice")
+	if s.config.Labels["conman.auth"] != "alice" || s.config.Labels["team"] != "web" {
+		t.Errorf("labels %v", s.config.Labels)
+	}
+	setAuthLabel(s.config.Labels, "conman.auth", "")
+	if _, ok := s.config.Labels["conman.auth"]; ok {
+		t.Errorf("auth label kept without a subject: %v", s.config.Labels)
+	}
+}
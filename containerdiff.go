@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RouteContainerDiff       = "containerDiff"
+	RouteContainerDiffExport = "containerDiffExport"
+
+	// deletedFileName lists the deleted paths in a diff export.
+	deletedFileName = "conman-deleted.txt"
+)
+
+func NewContainerDiffLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerDiff, "diff", "GET", "id", id)
+}
+
+// changeKinds names the kinds of the container changes API, in order.
+var changeKinds = []string{"changed", "added", "deleted"}
+
+type ContainerChange struct {
+	Path string `json:"path"`
+	// Kind is added, changed or deleted.
+	Kind string `json:"kind"`
+}
+
+// ContainerChangeDirectory holds the changes directly in a directory.
+type ContainerChangeDirectory struct {
+	Path    string            `json:"path"`
+	Changes []ContainerChange `json:"changes"`
+}
+
+type ContainerDiff struct {
+	Added       int                        `json:"added"`
+	Changed     int                        `json:"changed"`
+	Deleted     int                        `json:"deleted"`
+	Directories []ContainerChangeDirectory `json:"directories"`
+	Links       ContainerDiffLinks         `json:"links"`
+}
+
+type ContainerDiffLinks struct {
+	Export *hateoasLink `json:"export,omitempty"`
+}
+
+func changeKind(k int) string {
+	if k < 0 || k >= len(changeKinds) {
+		return "unknown"
+	}
+	return changeKinds[k]
+}
+
+// DiffContainer lists the paths added, changed or deleted in the writable
+// layer of the container, grouped by directory.
+func DiffContainer(auth Authenticator, links Linker) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		changes, err := cli.ContainerDiff(r.Context(), containerID)
+		if err != nil {
+			return err
+		}
+
+		diff := ContainerDiff{Directories: []ContainerChangeDirectory{}}
+		byDir := map[string][]ContainerChange{}
+		for _, c := range changes {
+			kind := changeKind(c.Kind)
+			switch kind {
+			case "added":
+				diff.Added++
+			case "changed":
+				diff.Changed++
+			case "deleted":
+				diff.Deleted++
+			}
+			dir := path.Dir(c.Path)
+			byDir[dir] = append(byDir[dir], ContainerChange{Path: c.Path, Kind: kind})
+		}
+		for dir, cs := range byDir {
+			sort.Slice(cs, func(i, j int) bool { return cs[i].Path < cs[j].Path })
+			diff.Directories = append(diff.Directories, ContainerChangeDirectory{Path: dir, Changes: cs})
+		}
+		sort.Slice(diff.Directories, func(i, j int) bool { return diff.Directories[i].Path < diff.Directories[j].Path })
+		if auth.HasRole(r, RoleFiles) {
+			diff.Links.Export = links.Link(r, RouteContainerDiffExport, "export", "GET", "id", containerID)
+		}
+
+		b, err := json.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
+
+// ExportContainerDiff sends the files and links added or changed in the
+// container as a tar archive, with the deleted paths listed in
+// conman-deleted.txt. Directories are left out, their changed content is
+// listed on its own.
+func ExportContainerDiff(auth Authenticator, maxBytes int64) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleFiles) {
+			return errForbidden
+		}
+		ctx, done := streams.Context(r)
+		defer done()
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		changes, err := cli.ContainerDiff(ctx, containerID)
+		if err != nil {
+			return err
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+		name, err := containerNameByID(ctx, containerID)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/x-tar")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "-diff.tar"}))
+		tw := tar.NewWriter(w)
+		deleted := []string{}
+		var total int64
+		for _, c := range changes {
+			if changeKind(c.Kind) == "deleted" {
+				deleted = append(deleted, c.Path)
+				continue
+			}
+			n, err := exportChangedFile(ctx, tw, containerID, c.Path, maxBytes-total)
+			if err == errExportLimit {
+				if err := writeTarNote(tw, truncatedFileName, truncatedNote(maxBytes)); err != nil {
+					return err
+				}
+				return tw.Close()
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			total += n
+		}
+		if len(deleted) > 0 {
+			if err := writeTarNote(tw, deletedFileName, strings.Join(deleted, "\n")+"\n"); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	}
+}
+
+var errExportLimit = errors.New("export limit reached")
+
+// exportChangedFile copies p from the container into tw under its full path,
+// unless it is a directory or gone. It returns errExportLimit when the file
+// is larger than limit.
+func exportChangedFile(ctx context.Context, tw *tar.Writer, containerID, p string, limit int64) (int64, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return 0, err
+	}
+	rc, stat, err := cli.CopyFromContainer(ctx, containerID, p)
+	if err != nil {
+		if classifyError(err).Status == http.StatusNotFound {
+			// Deleted since the diff was taken.
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer rc.Close()
+	if stat.Mode.IsDir() {
+		return 0, nil
+	}
+	if stat.Mode&os.ModeSymlink == 0 && stat.Size > limit {
+		return 0, errExportLimit
+	}
+	tr := tar.NewReader(rc)
+	hdr, err := tr.Next()
+	if err != nil {
+		return 0, err
+	}
+	hdr.Name = strings.TrimPrefix(p, "/")
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	return io.Copy(tw, tr)
+}
+
+func writeTarNote(tw *tar.Writer, name, note string) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(note)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(tw, note)
+	return err
+}
+
+func containerNameByID(ctx context.Context, containerID string) (string, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return "", err
+	}
+	ci, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(ci.Name, "/"), nil
+}
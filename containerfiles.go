@@ -0,0 +1,529 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// RoleFiles may browse, download and upload files of the containers they
+// are allowed to manage.
+const RoleFiles = "files"
+
+const (
+	RouteContainerFiles        = "containerFiles"
+	RouteContainerFileDownload = "containerFileDownload"
+
+	// maxFileListEntries and maxFileListRead bound listing a directory, the
+	// copy API sends the whole tree below it.
+	maxFileListEntries = 5000
+	maxFileListRead    = 256 << 20
+	// listDirectoryScript prints mode, size, modification time and name of
+	// up to $2 entries of the directory $1, one level deep, and truncated
+	// when there are more.
+	listDirectoryScript = `cd -- "$1" || exit 2; max=$2; n=0; set --; for f in * .[!.]* ..?*; do if [ -e "$f" ] || [ -L "$f" ]; then n=$((n+1)); if [ "$n" -gt "$max" ]; then echo truncated; break; fi; set -- "$@" "$f"; fi; done; [ "$#" -eq 0 ] || exec stat -c '%f/%s/%Y/%n' -- "$@"`
+	// truncatedFileName is added to archives cut off at the download limit.
+	truncatedFileName = "conman-truncated.txt"
+)
+
+func NewContainerFilesLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerFiles, "files", "GET", "id", id)
+}
+
+type ContainerFile struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Dir        bool      `json:"dir"`
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"`
+	ModTime    time.Time `json:"modTime"`
+	LinkTarget string    `json:"linkTarget,omitempty"`
+}
+
+type ContainerDirectory struct {
+	Path    string          `json:"path"`
+	Entries []ContainerFile `json:"entries"`
+	// Truncated is set when the directory was too large to list fully.
+	Truncated bool                    `json:"truncated"`
+	Links     ContainerDirectoryLinks `json:"links"`
+}
+
+// ContainerDirectoryLinks take the path of a file or directory in the path
+// query parameter.
+type ContainerDirectoryLinks struct {
+	Download *hateoasLink `json:"download,omitempty"`
+	Upload   *hateoasLink `json:"upload,omitempty"`
+}
+
+func errTooLarge(message string) *apiError {
+	return &apiError{Status: http.StatusRequestEntityTooLarge, Code: "too_large", Message: message}
+}
+
+// containerPath returns the cleaned path query parameter, / when not given.
+func containerPath(r *http.Request) (string, error) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		return "/", nil
+	}
+	if !path.IsAbs(p) {
+		return "", newBadRequestError(fmt.Sprintf("path %q is not absolute", p))
+	}
+	return path.Clean(p), nil
+}
+
+// statContainerPath stats p in the container, following a symbolic link.
+func statContainerPath(r *http.Request, containerID, p string) (string, types.ContainerPathStat, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return p, types.ContainerPathStat{}, err
+	}
+	stat, err := cli.ContainerStatPath(r.Context(), containerID, p)
+	if err != nil {
+		return p, stat, err
+	}
+	if stat.Mode&os.ModeSymlink != 0 && stat.LinkTarget != "" {
+		p = stat.LinkTarget
+		stat, err = cli.ContainerStatPath(r.Context(), containerID, p)
+	}
+	return p, stat, err
+}
+
+// ListContainerFiles lists the directory given by the path query parameter.
+func ListContainerFiles(auth Authenticator, links Linker) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleFiles) {
+			return errForbidden
+		}
+		dir, err := containerPath(r)
+		if err != nil {
+			return err
+		}
+		dir, stat, err := statContainerPath(r, containerID, dir)
+		if err != nil {
+			return err
+		}
+		if !stat.Mode.IsDir() {
+			return newBadRequestError(fmt.Sprintf("%s is not a directory", dir))
+		}
+		listing := ContainerDirectory{Path: dir}
+		listing.Links.Download = links.Link(r, RouteContainerFileDownload, "download", "GET", "id", containerID)
+		listing.Links.Upload = links.Link(r, RouteContainerFiles, "upload", "POST", "id", containerID)
+		ok, err := listDirectoryExec(r.Context(), containerID, &listing)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := listDirectoryTar(r.Context(), containerID, &listing); err != nil {
+				return err
+			}
+		}
+		sort.Slice(listing.Entries, func(i, j int) bool { return listing.Entries[i].Name < listing.Entries[j].Name })
+		b, err := json.Marshal(listing)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
+
+// listDirectoryExec lists the directory with stat in the container, one
+// level deep. It reports false when the container is not running or has no
+// shell or stat to run.
+func listDirectoryExec(ctx context.Context, containerID string, listing *ContainerDirectory) (bool, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return false, err
+	}
+	ci, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, err
+	}
+	if !ci.State.Running {
+		return false, nil
+	}
+	stdout, _, code, err := execInContainer(ctx, containerID, []string{"sh", "-c", listDirectoryScript, "sh", listing.Path, strconv.Itoa(maxFileListEntries)})
+	if err != nil {
+		return false, err
+	}
+	// stat exits with 1 when an entry is removed while listing.
+	if code != 0 && code != 1 {
+		return false, nil
+	}
+	listing.Entries = []ContainerFile{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if line == "truncated" {
+			listing.Truncated = true
+			continue
+		}
+		// Names can not hold a slash, it separates the fields.
+		fields := strings.SplitN(line, "/", 4)
+		if len(fields) < 4 || fields[3] == "" {
+			continue
+		}
+		raw, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		mtime, _ := strconv.ParseInt(fields[2], 10, 64)
+		mode := unixFileMode(uint32(raw))
+		f := ContainerFile{
+			Name:    fields[3],
+			Path:    path.Join(listing.Path, fields[3]),
+			Dir:     mode.IsDir(),
+			Size:    size,
+			Mode:    mode.String(),
+			ModTime: time.Unix(mtime, 0).UTC(),
+		}
+		if mode&os.ModeSymlink != 0 {
+			if stat, err := cli.ContainerStatPath(ctx, containerID, f.Path); err == nil {
+				f.LinkTarget = stat.LinkTarget
+			}
+		}
+		listing.Entries = append(listing.Entries, f)
+	}
+	return true, nil
+}
+
+// unixFileMode converts the st_mode of stat to an os.FileMode.
+func unixFileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// listDirectoryTar lists the directory from the archive of the copy API,
+// which holds the whole tree below it.
+func listDirectoryTar(ctx context.Context, containerID string, listing *ContainerDirectory) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	rc, _, err := cli.CopyFromContainer(ctx, containerID, listing.Path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	listing.Entries = []ContainerFile{}
+	// The first entry is the directory itself, the others are named after
+	// it, such as etc/hosts.
+	prefix := ""
+	lr := &io.LimitedReader{R: rc, N: maxFileListRead}
+	tr := tar.NewReader(lr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if lr.N <= 0 {
+				break
+			}
+			return err
+		}
+		if prefix == "" {
+			prefix = strings.TrimSuffix(hdr.Name, "/") + "/"
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(hdr.Name, prefix), "./"), "/")
+		if name == "" || name == "." || strings.Contains(name, "/") {
+			continue
+		}
+		if len(listing.Entries) == maxFileListEntries {
+			listing.Truncated = true
+			break
+		}
+		fi := hdr.FileInfo()
+		listing.Entries = append(listing.Entries, ContainerFile{
+			Name:       name,
+			Path:       path.Join(listing.Path, name),
+			Dir:        fi.IsDir(),
+			Size:       hdr.Size,
+			Mode:       fi.Mode().String(),
+			ModTime:    hdr.ModTime.UTC(),
+			LinkTarget: hdr.Linkname,
+		})
+	}
+	// The limit may end the archive on a block boundary, which reads as its
+	// end.
+	if lr.N <= 0 {
+		listing.Truncated = true
+	}
+	return nil
+}
+
+// DownloadContainerFiles sends the file given by the path query parameter
+// as is and directories as a tar archive, or a zip archive with format=zip.
+// Archives larger than maxBytes are cut off at a file boundary and get a
+// note saying so.
+func DownloadContainerFiles(auth Authenticator, maxBytes int64) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleFiles) {
+			return errForbidden
+		}
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "tar" && format != "zip" {
+			return newBadRequestError(fmt.Sprintf("unknown format %q, use tar or zip", format))
+		}
+		p, err := containerPath(r)
+		if err != nil {
+			return err
+		}
+		p, stat, err := statContainerPath(r, containerID, p)
+		if err != nil {
+			return err
+		}
+		if stat.Mode.IsRegular() && stat.Size > maxBytes {
+			return errTooLarge(fmt.Sprintf("%s is larger than the download limit of %d bytes", p, maxBytes))
+		}
+
+		ctx, done := streams.Context(r)
+		defer done()
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		rc, _, err := cli.CopyFromContainer(ctx, containerID, p)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		tr := tar.NewReader(rc)
+
+		name := path.Base(p)
+		if name == "/" {
+			name = "root"
+		}
+		if stat.Mode.IsRegular() && format == "" {
+			if _, err := tr.Next(); err != nil {
+				return err
+			}
+			w.Header().Set("Content-type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+			_, err := io.Copy(w, tr)
+			return err
+		}
+
+		if format == "zip" {
+			w.Header().Set("Content-type", "application/zip")
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+			return tarToZip(tr, zip.NewWriter(w), maxBytes)
+		}
+		w.Header().Set("Content-type", "application/x-tar")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".tar"}))
+		return copyTar(tr, tar.NewWriter(w), maxBytes)
+	}
+}
+
+func truncatedNote(maxBytes int64) string {
+	return fmt.Sprintf("The download was cut off at the limit of %d bytes, the remaining files are missing.\n", maxBytes)
+}
+
+// copyTar copies the entries of tr to tw until they add up to more than
+// maxBytes.
+func copyTar(tr *tar.Reader, tw *tar.Writer, maxBytes int64) error {
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if total += hdr.Size; total > maxBytes {
+			if err := writeTarNote(tw, truncatedFileName, truncatedNote(maxBytes)); err != nil {
+				return err
+			}
+			return tw.Close()
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// tarToZip converts the directories, regular files and symbolic links of tr
+// to zw until they add up to more than maxBytes.
+func tarToZip(tr *tar.Reader, zw *zip.Writer, maxBytes int64) error {
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if total += hdr.Size; total > maxBytes {
+			f, err := createZipFile(zw, truncatedFileName)
+			if err != nil {
+				return err
+			}
+			io.WriteString(f, truncatedNote(maxBytes))
+			return zw.Close()
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeSymlink:
+		default:
+			continue
+		}
+		zh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		zh.Name = hdr.Name
+		if hdr.Typeflag == tar.TypeDir {
+			zh.Name = strings.TrimSuffix(hdr.Name, "/") + "/"
+		} else {
+			zh.Method = zip.Deflate
+		}
+		f, err := zw.CreateHeader(zh)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			// Zip stores the target of a link as its content.
+			_, err = io.WriteString(f, hdr.Linkname)
+		case tar.TypeReg, tar.TypeRegA:
+			_, err = io.Copy(f, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// UploadContainerFiles copies the files of a multipart/form-data request,
+// or the entries of an application/x-tar request, into the directory given
+// by the path query parameter.
+func UploadContainerFiles(auth Authenticator, maxBytes int64) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleFiles) {
+			return errForbidden
+		}
+		dir, err := containerPath(r)
+		if err != nil {
+			return err
+		}
+		dir, stat, err := statContainerPath(r, containerID, dir)
+		if err != nil {
+			return err
+		}
+		if !stat.Mode.IsDir() {
+			return newBadRequestError(fmt.Sprintf("%s is not a directory", dir))
+		}
+		if r.ContentLength > maxBytes {
+			return errTooLarge(fmt.Sprintf("uploads are limited to %d bytes", maxBytes))
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+		var content io.Reader
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/x-tar":
+			content = r.Body
+		case "multipart/form-data":
+			if err := r.ParseMultipartForm(32 << 20); err != nil {
+				if err.Error() == "http: request body too large" {
+					return errTooLarge(fmt.Sprintf("uploads are limited to %d bytes", maxBytes))
+				}
+				return newBadRequestError(fmt.Sprintf("invalid upload: %v", err))
+			}
+			defer r.MultipartForm.RemoveAll()
+			files := r.MultipartForm.File["file"]
+			if len(files) == 0 {
+				return newBadRequestError("no files in the file field")
+			}
+			pr, pw := io.Pipe()
+			go func() {
+				tw := tar.NewWriter(pw)
+				for _, fh := range files {
+					if err := writeTarFile(tw, fh.Filename, fh.Size, fh.Open); err != nil {
+						pw.CloseWithError(err)
+						return
+					}
+				}
+				pw.CloseWithError(tw.Close())
+			}()
+			defer pr.Close()
+			content = pr
+		default:
+			return newBadRequestError("upload files as multipart/form-data or a tar archive as application/x-tar")
+		}
+
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		if err := cli.CopyToContainer(r.Context(), containerID, dir, content, types.CopyToContainerOptions{}); err != nil {
+			if strings.Contains(err.Error(), "http: request body too large") {
+				return errTooLarge(fmt.Sprintf("uploads are limited to %d bytes", maxBytes))
+			}
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, open func() (multipart.File, error)) error {
+	// Browsers may send a path, only the name is used.
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return newBadRequestError("invalid file name")
+	}
+	f, err := open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const composeProjectLabel = "com.docker.compose.project"
+
+var containerMetricLabels = []string{"name", "image", "compose_project", "auth_id"}
+
+type containerSample struct {
+	labels       []string
+	cpuSeconds   float64
+	memoryUsage  float64
+	memoryLimit  float64
+	rxBytes      float64
+	txBytes      float64
+	restartCount float64
+}
+
+// ContainerStatsCollector polls the stats API for all running containers in
+// the background and exposes the latest samples as Prometheus metrics, much
+// like cAdvisor.
+type ContainerStatsCollector struct {
+	Interval    time.Duration
+	Concurrency int
+	// LabelKey is the label exposed as auth_id.
+	LabelKey string
+	ErrLog   *log.Logger
+
+	mu      sync.RWMutex
+	samples []containerSample
+}
+
+// Run collects every Interval until ctx is done.
+func (csc *ContainerStatsCollector) Run(ctx context.Context) {
+	ticker := time.NewTicker(csc.Interval)
+	defer ticker.Stop()
+	for {
+		if err := csc.collect(ctx); err != nil && ctx.Err() == nil {
+			csc.ErrLog.Printf("collecting container stats: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (csc *ContainerStatsCollector) collect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, csc.Interval)
+	defer cancel()
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	cs, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	samples := make([]containerSample, len(cs))
+	ok := make([]bool, len(cs))
+	sem := make(chan struct{}, csc.Concurrency)
+	var wg sync.WaitGroup
+	for i, c := range cs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, c types.Container) {
+			defer func() { <-sem; wg.Done() }()
+			s, err := csc.sample(ctx, c)
+			if err != nil {
+				// The container most likely stopped since it was listed.
+				return
+			}
+			samples[i], ok[i] = s, true
+		}(i, c)
+	}
+	wg.Wait()
+
+	kept := samples[:0]
+	for i, s := range samples {
+		if ok[i] {
+			kept = append(kept, s)
+		}
+	}
+	csc.mu.Lock()
+	csc.samples = kept
+	csc.mu.Unlock()
+	return nil
+}
+
+func (csc *ContainerStatsCollector) sample(ctx context.Context, c types.Container) (containerSample, error) {
+	s := containerSample{}
+	cli, err := newDockerClient()
+	if err != nil {
+		return s, err
+	}
+	stats, err := cli.ContainerStats(ctx, c.ID, false)
+	if err != nil {
+		return s, err
+	}
+	defer stats.Body.Close()
+	var sj types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&sj); err != nil {
+		return s, err
+	}
+	ci, err := cli.ContainerInspect(ctx, c.ID)
+	if err != nil {
+		return s, err
+	}
+
+	name := c.ID
+	if len(c.Names) > 0 {
+		name = c.Names[0][1:]
+	}
+	s.labels = []string{name, c.Image, c.Labels[composeProjectLabel], c.Labels[csc.LabelKey]}
+	s.cpuSeconds = float64(sj.CPUStats.CPUUsage.TotalUsage) / 1e9
+	// Page cache can be reclaimed, report what the container actually holds.
+	if cache := sj.MemoryStats.Stats["cache"]; cache < sj.MemoryStats.Usage {
+		s.memoryUsage = float64(sj.MemoryStats.Usage - cache)
+	}
+	s.memoryLimit = float64(sj.MemoryStats.Limit)
+	for _, n := range sj.Networks {
+		s.rxBytes += float64(n.RxBytes)
+		s.txBytes += float64(n.TxBytes)
+	}
+	s.restartCount = float64(ci.RestartCount)
+	return s, nil
+}
+
+// Collect writes the latest samples, it is registered with the metrics.
+func (csc *ContainerStatsCollector) Collect(w io.Writer) {
+	csc.mu.RLock()
+	defer csc.mu.RUnlock()
+	families := []struct {
+		name, help, typ string
+		value           func(s containerSample) float64
+	}{
+		{"conman_container_cpu_usage_seconds_total", "Cumulative CPU time consumed by the container.", "counter", func(s containerSample) float64 { return s.cpuSeconds }},
+		{"conman_container_memory_usage_bytes", "Memory used by the container, excluding page cache.", "gauge", func(s containerSample) float64 { return s.memoryUsage }},
+		{"conman_container_memory_limit_bytes", "Memory limit of the container.", "gauge", func(s containerSample) float64 { return s.memoryLimit }},
+		{"conman_container_network_receive_bytes_total", "Bytes received on all container networks.", "counter", func(s containerSample) float64 { return s.rxBytes }},
+		{"conman_container_network_transmit_bytes_total", "Bytes transmitted on all container networks.", "counter", func(s containerSample) float64 { return s.txBytes }},
+		{"conman_container_restarts_total", "Times the container has been restarted by Docker.", "counter", func(s containerSample) float64 { return s.restartCount }},
+	}
+	for _, f := range families {
+		writeMetricHeader(w, f.name, f.help, f.typ)
+		for _, s := range csc.samples {
+			writeSample(w, f.name, containerMetricLabels, s.labels, f.value(s))
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+)
+
+const (
+	RouteContainerTop           = "containerTop"
+	RouteContainerProcessSignal = "containerProcessSignal"
+
+	defaultPsArgs = "aux"
+	// listProcessesScript prints the PID, the first line of sched and stat of
+	// every process in the PID namespace of the container, tab separated.
+	listProcessesScript = `for d in /proc/[0-9]*; do s=; t=; { read -r s < "$d/sched"; read -r t < "$d/stat"; } 2>/dev/null; printf '%s\t%s\t%s\n' "${d#/proc/}" "$s" "$t"; done`
+	maxExecOutput       = 4 << 20
+	// userHZ is the unit of process start times in /proc, 100 on every
+	// architecture Docker runs on.
+	userHZ = 100
+	// lstartLayout is the format of the lstart column of ps, with repeated
+	// spaces collapsed.
+	lstartLayout = "Mon Jan 2 15:04:05 2006"
+)
+
+// psArgsPattern keeps ps arguments to options and column lists.
+var psArgsPattern = regexp.MustCompile(`^[A-Za-z0-9 ,=%_-]{1,64}$`)
+
+// processSignals can be sent to processes, without the SIG prefix.
+var processSignals = []string{"HUP", "INT", "QUIT", "KILL", "USR1", "USR2", "TERM", "CONT", "STOP"}
+
+func NewContainerTopLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerTop, "top", "GET", "id", id)
+}
+
+type ContainerTop struct {
+	Titles    []string           `json:"titles"`
+	Processes []ContainerProcess `json:"processes"`
+	// Signals are those that can be sent, when the caller may send any.
+	Signals []string `json:"signals,omitempty"`
+}
+
+// ContainerProcess is a row of the process list. The structured fields are
+// taken from the columns ps printed for them and are empty when it did not.
+type ContainerProcess struct {
+	// PID is the process ID on the host.
+	PID     int     `json:"pid"`
+	User    string  `json:"user"`
+	CPU     float64 `json:"cpu"`
+	Memory  float64 `json:"memory"`
+	Command string  `json:"command"`
+	// Fields holds all columns, in the order of the titles.
+	Fields []string              `json:"fields"`
+	Links  ContainerProcessLinks `json:"links"`
+}
+
+type ContainerProcessLinks struct {
+	Signal *hateoasLink `json:"signal,omitempty"`
+}
+
+type ProcessSignalRequest struct {
+	Signal string `json:"signal"`
+}
+
+// topColumn returns the index of the first of titles ps uses for a column.
+func topColumn(titles []string, names ...string) int {
+	for i, t := range titles {
+		for _, n := range names {
+			if strings.EqualFold(t, n) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func topField(p []string, i int) string {
+	if i < 0 || i >= len(p) {
+		return ""
+	}
+	return p[i]
+}
+
+// TopContainer lists the processes of a running container using ps on the
+// host, with the arguments in psArgs.
+func TopContainer(auth Authenticator, links Linker) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		psArgs := r.URL.Query().Get("psArgs")
+		if psArgs == "" {
+			psArgs = defaultPsArgs
+		}
+		if !psArgsPattern.MatchString(psArgs) {
+			return newBadRequestError("psArgs may only hold ps options and column names")
+		}
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		list, err := cli.ContainerTop(r.Context(), containerID, []string{psArgs})
+		if err != nil {
+			return err
+		}
+
+		signal := auth.HasRole(r, RoleOperator)
+		pidCol := topColumn(list.Titles, "PID")
+		userCol := topColumn(list.Titles, "USER", "UID", "EUSER", "RUSER")
+		cpuCol := topColumn(list.Titles, "%CPU", "C", "PCPU")
+		memCol := topColumn(list.Titles, "%MEM", "PMEM")
+		cmdCol := topColumn(list.Titles, "COMMAND", "CMD", "ARGS")
+		top := ContainerTop{Titles: list.Titles, Processes: []ContainerProcess{}}
+		if top.Titles == nil {
+			top.Titles = []string{}
+		}
+		for _, p := range list.Processes {
+			proc := ContainerProcess{User: topField(p, userCol), Command: topField(p, cmdCol), Fields: p}
+			proc.PID, _ = strconv.Atoi(topField(p, pidCol))
+			proc.CPU, _ = strconv.ParseFloat(topField(p, cpuCol), 64)
+			proc.Memory, _ = strconv.ParseFloat(topField(p, memCol), 64)
+			if signal && proc.PID > 0 {
+				proc.Links.Signal = links.Link(r, RouteContainerProcessSignal, "signal", "POST", "id", containerID, "pid", strconv.Itoa(proc.PID))
+			}
+			top.Processes = append(top.Processes, proc)
+		}
+		if signal {
+			top.Signals = processSignals
+		}
+
+		b, err := json.Marshal(top)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
+
+// SignalContainerProcess sends a signal to a process of the container, given
+// by its PID on the host as listed by TopContainer, by running kill in the
+// container. The container needs a shell for it.
+func SignalContainerProcess(auth Authenticator) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleOperator) {
+			return errForbidden
+		}
+		pid, err := strconv.Atoi(mux.Vars(r)["pid"])
+		if err != nil || pid <= 0 {
+			return newBadRequestError("invalid process ID")
+		}
+		var req ProcessSignalRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return newBadRequestError(fmt.Sprintf("invalid signal request: %v", err))
+		}
+		signal := strings.TrimPrefix(strings.ToUpper(req.Signal), "SIG")
+		if !containsString(processSignals, signal) {
+			return newBadRequestError(fmt.Sprintf("signal must be one of %s", strings.Join(processSignals, ", ")))
+		}
+
+		nsPID, err := containerNamespacePID(r.Context(), containerID, pid)
+		if err != nil {
+			return err
+		}
+		_, stderr, code, err := execInContainer(r.Context(), containerID, []string{"sh", "-c", `kill -s "$1" "$2"`, "sh", signal, strconv.Itoa(nsPID)})
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return execFailedError(code, stderr)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// containerNamespacePID translates a host PID, as listed by docker top, to
+// the PID the process has inside the container. The init process of the
+// container is known from inspecting it. Older kernels show the host PID in
+// /proc/<pid>/sched inside the container, newer ones show the PID of the
+// namespace there. Then processes are matched on their name and start time,
+// which ps gives in local seconds on the host and /proc/<pid>/stat in ticks
+// since boot, aligned using the init process. Processes that can not be
+// matched exactly are refused.
+func containerNamespacePID(ctx context.Context, containerID string, pid int) (int, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return 0, err
+	}
+	ci, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return 0, err
+	}
+	if !ci.State.Running {
+		return 0, &apiError{Status: http.StatusConflict, Code: "conflict", Message: "container is not running"}
+	}
+	if ci.HostConfig != nil && ci.HostConfig.PidMode.IsHost() {
+		return pid, nil
+	}
+	if pid == ci.State.Pid {
+		return 1, nil
+	}
+
+	list, err := cli.ContainerTop(ctx, containerID, []string{"-eo", "pid,lstart,comm"})
+	if err != nil {
+		return 0, err
+	}
+	hostProcs := []hostProcess{}
+	for _, p := range list.Processes {
+		if hp, ok := parseHostProcess(p); ok {
+			hostProcs = append(hostProcs, hp)
+		}
+	}
+	stdout, stderr, code, err := execInContainer(ctx, containerID, []string{"sh", "-c", listProcessesScript})
+	if err != nil {
+		return 0, err
+	}
+	if code != 0 {
+		return 0, execFailedError(code, stderr)
+	}
+	nsProcs := []nsProcess{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if np, ok := parseNSProcess(line); ok {
+			nsProcs = append(nsProcs, np)
+		}
+	}
+	return matchNSProcess(pid, ci.State.Pid, hostProcs, nsProcs)
+}
+
+// matchNSProcess finds the process with the host PID pid among the
+// processes of the container's namespace. initPID is the host PID of its
+// init process.
+func matchNSProcess(pid, initPID int, hostProcs []hostProcess, nsProcs []nsProcess) (int, error) {
+	var target, hostInit *hostProcess
+	for i := range hostProcs {
+		if hostProcs[i].pid == pid {
+			target = &hostProcs[i]
+		}
+		if hostProcs[i].pid == initPID {
+			hostInit = &hostProcs[i]
+		}
+	}
+	if target == nil {
+		return 0, &apiError{Status: http.StatusNotFound, Code: "not_found", Message: fmt.Sprintf("no process %d in the container", pid)}
+	}
+	var nsInit *nsProcess
+	for i := range nsProcs {
+		if nsProcs[i].pid == 1 {
+			nsInit = &nsProcs[i]
+		}
+	}
+	if hostInit == nil || nsInit == nil {
+		return 0, errProcessNotIdentified(pid)
+	}
+
+	// The init process shows its host PID in sched on kernels that show
+	// host PIDs there.
+	bySched := nsInit.schedPID == initPID
+	// offset converts start times in the container to those of ps.
+	offset := hostInit.started - int64(nsInit.ticks/userHZ)
+	match := 0
+	for _, np := range nsProcs {
+		var ok bool
+		if bySched {
+			ok = np.schedPID == pid
+		} else {
+			ok = np.comm == target.comm && int64(np.ticks/userHZ)+offset == target.started
+		}
+		if ok {
+			if match != 0 {
+				return 0, errProcessNotIdentified(pid)
+			}
+			match = np.pid
+		}
+	}
+	if match == 0 {
+		return 0, errProcessNotIdentified(pid)
+	}
+	return match, nil
+}
+
+func errProcessNotIdentified(pid int) *apiError {
+	return &apiError{Status: http.StatusConflict, Code: "conflict", Message: fmt.Sprintf("process %d could not be identified inside the container", pid)}
+}
+
+// hostProcess is a process as listed by ps -o pid,lstart,comm on the host.
+type hostProcess struct {
+	pid int
+	// started is the local start time on the host, as if it was UTC.
+	started int64
+	comm    string
+}
+
+func parseHostProcess(p []string) (hostProcess, bool) {
+	if len(p) < 2 {
+		return hostProcess{}, false
+	}
+	pid, err := strconv.Atoi(p[0])
+	if err != nil {
+		return hostProcess{}, false
+	}
+	// Docker splits the columns on spaces, leaving the rest of lstart and
+	// comm in the last column.
+	fields := strings.Fields(strings.Join(p[1:], " "))
+	if len(fields) < 6 {
+		return hostProcess{}, false
+	}
+	started, err := time.Parse(lstartLayout, strings.Join(fields[:5], " "))
+	if err != nil {
+		return hostProcess{}, false
+	}
+	return hostProcess{pid: pid, started: started.Unix(), comm: strings.Join(fields[5:], " ")}, true
+}
+
+// nsProcess is a process as listed by listProcessesScript.
+type nsProcess struct {
+	pid      int
+	schedPID int
+	// ticks is the start time in ticks since boot.
+	ticks uint64
+	comm  string
+}
+
+func parseNSProcess(line string) (nsProcess, bool) {
+	cols := strings.SplitN(line, "\t", 3)
+	if len(cols) < 3 {
+		return nsProcess{}, false
+	}
+	var np nsProcess
+	var err error
+	if np.pid, err = strconv.Atoi(cols[0]); err != nil {
+		return nsProcess{}, false
+	}
+	// sched starts with "comm (pid, #threads: n)".
+	if i := strings.LastIndex(cols[1], "("); i >= 0 {
+		if j := strings.IndexByte(cols[1][i:], ','); j > 0 {
+			np.schedPID, _ = strconv.Atoi(cols[1][i+1 : i+j])
+		}
+	}
+	// stat is "pid (comm) state ppid ...", comm may hold spaces and
+	// parentheses, the start time is the 22nd field.
+	stat := cols[2]
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return nsProcess{}, false
+	}
+	np.comm = strings.Join(strings.Fields(stat[open+1:end]), " ")
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return nsProcess{}, false
+	}
+	if np.ticks, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return nsProcess{}, false
+	}
+	return np, true
+}
+
+// execInContainer runs cmd in the container and returns its output and exit
+// code.
+func execInContainer(ctx context.Context, containerID string, cmd []string) (string, string, int, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return "", "", 0, err
+	}
+	config := types.ExecConfig{Cmd: cmd, AttachStdout: true, AttachStderr: true}
+	created, err := cli.ContainerExecCreate(ctx, containerID, config)
+	if err != nil {
+		return "", "", 0, err
+	}
+	resp, err := cli.ContainerExecAttach(ctx, created.ID, config)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Close()
+	var stdout, stderr bytes.Buffer
+	ls := newLogScanner(io.LimitReader(resp.Reader, maxExecOutput), false, false)
+	for ls.Scan() {
+		out := &stdout
+		if ls.Line().Stream == "stderr" {
+			out = &stderr
+		}
+		out.WriteString(ls.Line().Text + "\n")
+	}
+	if err := ls.Err(); err != nil {
+		return "", "", 0, err
+	}
+	// The output may end before the exec is done, the exit code is only
+	// known once it is.
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+		if err != nil {
+			return "", "", 0, err
+		}
+		if !inspect.Running {
+			return stdout.String(), stderr.String(), inspect.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", 0, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func execFailedError(code int, stderr string) *apiError {
+	msg := strings.TrimSpace(stderr)
+	switch {
+	case code == 126 || code == 127:
+		msg = "the container has no shell to run kill with"
+	case msg == "":
+		msg = fmt.Sprintf("exited with code %d", code)
+	}
+	return &apiError{Status: http.StatusConflict, Code: "conflict", Message: msg}
+}
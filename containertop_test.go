@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// procStat formats /proc/<pid>/stat for a process started ticks after boot.
+func procStat(pid int, comm string, ticks uint64) string {
+	return fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 %d 1000000 200", pid, comm, pid, pid, ticks)
+}
+
+func TestParseHostProcess(t *testing.T) {
+	started := time.Date(2026, time.October, 5, 16, 4, 5, 0, time.UTC).Unix()
+	tests := []struct {
+		row  []string
+		want hostProcess
+		ok   bool
+	}{
+		{[]string{"1234", "Mon", "Oct  5 16:04:05 2026 nginx"}, hostProcess{1234, started, "nginx"}, true},
+		{[]string{"1234", "Mon Oct 5 16:04:05 2026", "php-fpm: pool www"}, hostProcess{1234, started, "php-fpm: pool www"}, true},
+		{[]string{"PID", "Mon", "Oct  5 16:04:05 2026 nginx"}, hostProcess{}, false},
+		{[]string{"1234", "Mon", "Oct  5 16:04:05 2026"}, hostProcess{}, false},
+		{[]string{"1234", "yesterday", "nginx"}, hostProcess{}, false},
+		{[]string{"1234"}, hostProcess{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseHostProcess(tt.row)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%q: got %+v %v, want %+v %v", tt.row, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseNSProcess(t *testing.T) {
+	tests := []struct {
+		line string
+		want nsProcess
+		ok   bool
+	}{
+		{"7\tnginx (2001, #threads: 1)\t" + procStat(7, "nginx", 503000), nsProcess{7, 2001, 503000, "nginx"}, true},
+		{"9\t(sd-pam) x (9, #threads: 2)\t" + procStat(9, "(sd-pam) x", 42), nsProcess{9, 9, 42, "(sd-pam) x"}, true},
+		{"7\t\t" + procStat(7, "nginx", 503000), nsProcess{7, 0, 503000, "nginx"}, true},
+		{"7\tnginx (7, #threads: 1)\t7 (nginx) S 1 7", nsProcess{}, false},
+		{"7\tnginx (7, #threads: 1)\t7 nginx S", nsProcess{}, false},
+		{"x\tnginx (7, #threads: 1)\t" + procStat(7, "nginx", 503000), nsProcess{}, false},
+		{"7\tnginx (7, #threads: 1)", nsProcess{}, false},
+		{"", nsProcess{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseNSProcess(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%q: got %+v %v, want %+v %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMatchNSProcess(t *testing.T) {
+	// The container started 5000 seconds after boot, at boot on the host.
+	const boot = 1790000000
+	host := []hostProcess{
+		{1000, boot + 5000, "sh"},
+		{1005, boot + 5030, "nginx"},
+		{1006, boot + 5030, "nginx"},
+		{1007, boot + 5040, "worker"},
+	}
+	// Newer kernels show the PID of the namespace in sched.
+	ns := []nsProcess{
+		{1, 1, 500000, "sh"},
+		{7, 7, 503000, "nginx"},
+		{8, 8, 503000, "nginx"},
+		{9, 9, 504000, "worker"},
+	}
+	// Older kernels show the host PID.
+	nsOld := []nsProcess{
+		{1, 1000, 500000, "sh"},
+		{7, 1005, 503000, "nginx"},
+		{8, 1006, 503000, "nginx"},
+		{9, 1007, 504000, "worker"},
+	}
+	tests := []struct {
+		name   string
+		pid    int
+		host   []hostProcess
+		ns     []nsProcess
+		want   int
+		status int
+	}{
+		{"start time", 1007, host, ns, 9, 0},
+		{"init", 1000, host, ns, 1, 0},
+		{"same start time", 1005, host, ns, 0, http.StatusConflict},
+		{"missing pid", 1010, host, ns, 0, http.StatusNotFound},
+		{"exited in the container", 1007, host, ns[:3], 0, http.StatusConflict},
+		{"no init in the container", 1007, host, ns[1:], 0, http.StatusConflict},
+		{"no init on the host", 1007, host[1:], ns, 0, http.StatusConflict},
+		{"sched", 1005, host, nsOld, 7, 0},
+		{"sched init", 1000, host, nsOld, 1, 0},
+		{"sched same start time", 1006, host, nsOld, 8, 0},
+		{"sched exited in the container", 1007, host, nsOld[:3], 0, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		got, err := matchNSProcess(tt.pid, 1000, tt.host, tt.ns)
+		if tt.status != 0 {
+			if ae, ok := err.(*apiError); !ok || ae.Status != tt.status {
+				t.Errorf("%s: got %d %v, want status %d", tt.name, got, err, tt.status)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %d %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
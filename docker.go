@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// dockerConfig is the daemon endpoint all Docker clients connect to, set from
+// the configuration at startup.
+var dockerConfig = DefaultConfig().Docker
+
+var (
+	dockerClientMu sync.Mutex
+	dockerClient   *client.Client
+)
+
+// newDockerClient returns the client shared by all handlers, it is safe for
+// concurrent use and reuses connections to the daemon. A failed connect is
+// tried again on the next call.
+func newDockerClient() (*client.Client, error) {
+	dockerClientMu.Lock()
+	defer dockerClientMu.Unlock()
+	if dockerClient != nil {
+		return dockerClient, nil
+	}
+	cli, err := connectDocker(dockerConfig)
+	if err != nil {
+		return nil, err
+	}
+	dockerClient = cli
+	return dockerClient, nil
+}
+
+func connectDocker(cfg DockerConfig) (*client.Client, error) {
+	proto, addr, _, err := client.ParseHost(cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	transport := new(http.Transport)
+	if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, err
+	}
+	if cfg.CertPath != "" {
+		options := tlsconfig.Options{
+			CAFile:             filepath.Join(cfg.CertPath, "ca.pem"),
+			CertFile:           filepath.Join(cfg.CertPath, "cert.pem"),
+			KeyFile:            filepath.Join(cfg.CertPath, "key.pem"),
+			InsecureSkipVerify: !cfg.TLSVerify,
+		}
+		tlsc, err := tlsconfig.Client(options)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsc
+	}
+	httpClient := &http.Client{Transport: transport}
+	cli, err := client.NewClient(cfg.Host, cfg.APIVersion, httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	// NewClient insists on an *http.Transport to find the TLS settings, the
+	// instrumentation is therefore wrapped around it afterwards.
+	httpClient.Transport = instrumentedTransport{base: transport}
+	return cli, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// apiError is an error with the HTTP status and machine readable code it
+// should be reported to the client with.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+var errForbidden = &apiError{Status: http.StatusForbidden, Code: "forbidden", Message: "access denied"}
+
+func newBadRequestError(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: "bad_request", Message: message}
+}
+
+func newForbiddenError(message string) *apiError {
+	return &apiError{Status: http.StatusForbidden, Code: "forbidden", Message: message}
+}
+
+// Phrases of Docker API errors that the client has no dedicated type for.
+var (
+	dockerNotFoundPhrases = []string{
+		"no such container", "no such image", "no such network", "no such volume",
+		"no such service", "no such task", "no such node", "no such exec",
+		"could not find the file", "request returned not found for api route", "manifest unknown",
+	}
+	dockerConflictPhrases = []string{
+		"is already in use", "cannot remove a running container", "is already in progress",
+		"conflict: unable to", "is not running",
+	}
+)
+
+// classifyError maps errors returned by handlers, most of which originate in
+// the Docker client, to the status and code sent to the client. Docker API
+// errors that have no dedicated type in the client are matched on the
+// phrases the daemon uses for them.
+func classifyError(err error) *apiError {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	msg := err.Error()
+	lmsg := strings.ToLower(msg)
+	switch {
+	case client.IsErrNotFound(err), containsPhrase(lmsg, dockerNotFoundPhrases),
+		strings.Contains(lmsg, "manifest for ") && strings.HasSuffix(lmsg, " not found"):
+		return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: msg, Err: err}
+	case client.IsErrUnauthorized(err):
+		return &apiError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: msg, Err: err}
+	case client.IsErrConnectionFailed(err), strings.Contains(lmsg, "is the docker daemon running"):
+		return &apiError{Status: http.StatusServiceUnavailable, Code: "unavailable", Message: msg, Err: err}
+	case containsPhrase(lmsg, dockerConflictPhrases):
+		return &apiError{Status: http.StatusConflict, Code: "conflict", Message: msg, Err: err}
+	}
+	return &apiError{Status: http.StatusInternalServerError, Code: "internal", Message: msg, Err: err}
+}
+
+func containsPhrase(s string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, ae *apiError) {
+	b, _ := json.Marshal(errorResponse{Code: ae.Code, Message: ae.Message, RequestID: requestID(r)})
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(ae.Status)
+	w.Write(b)
+}
+
+type requestIDKey struct{}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func withRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// normalizeImageRef adds the latest tag to references without a tag or
+// digest, as Docker does.
+func normalizeImageRef(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+// pullImage pulls ref, with the stored credentials of its registry, and
+// waits for the pull to finish. Errors during the pull are reported in the
+// progress stream rather than the response status.
+func pullImage(ctx context.Context, ref string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	registryAuth, err := registries.encodedAuth(ref)
+	if err != nil {
+		return err
+	}
+	rc, err := cli.ImagePull(ctx, ref, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	dec := json.NewDecoder(rc)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// ensureImage pulls ref unless it is already present.
+func ensureImage(ctx context.Context, ref string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	_, _, err = cli.ImageInspectWithRaw(ctx, ref)
+	if err == nil || classifyError(err).Status != http.StatusNotFound {
+		return err
+	}
+	return pullImage(ctx, ref)
+}
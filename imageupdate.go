@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// imageUpdates is set when checking for image updates is enabled.
+var imageUpdates *ImageUpdateChecker
+
+// manifestMediaTypes are accepted when asking a registry for the digest of a
+// tag, a multi-platform image is pulled by the digest of its list.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// ImageUpdateChecker compares the image digest of running containers with
+// the digest their tag has in the registry, using the distribution API.
+type ImageUpdateChecker struct {
+	Interval time.Duration
+	// InsecureRegistries are reached with plain HTTP, such as a local
+	// registry on localhost:5000.
+	InsecureRegistries []string
+	Client             *http.Client
+	ErrLog             *log.Logger
+
+	mu sync.RWMutex
+	// outdated holds the containers, by ID, whose image has a newer digest
+	// in the registry.
+	outdated map[string]bool
+}
+
+// UpdateAvailable reports if the image of the container was outdated at the
+// last check.
+func (iuc *ImageUpdateChecker) UpdateAvailable(containerID string) bool {
+	if iuc == nil {
+		return false
+	}
+	iuc.mu.RLock()
+	defer iuc.mu.RUnlock()
+	return iuc.outdated[containerID]
+}
+
+// Run checks every Interval until ctx is done.
+func (iuc *ImageUpdateChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(iuc.Interval)
+	defer ticker.Stop()
+	for {
+		if err := iuc.check(ctx); err != nil && ctx.Err() == nil {
+			iuc.ErrLog.Printf("checking for image updates: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (iuc *ImageUpdateChecker) check(ctx context.Context) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	cs, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Each tag and image is looked up once, containers often share them.
+	remote := map[string]string{}
+	repoDigests := map[string][]string{}
+	outdated := map[string]bool{}
+	for _, c := range cs {
+		ci, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(ci.Config.Image)
+		if err != nil {
+			// Created from an image ID.
+			continue
+		}
+		if _, ok := named.(reference.Canonical); ok {
+			// Pinned to a digest, there is nothing to update to.
+			continue
+		}
+		tagged := reference.TagNameOnly(named).(reference.NamedTagged)
+		key := tagged.String()
+		digest, ok := remote[key]
+		if !ok {
+			digest, err = iuc.remoteDigest(ctx, tagged)
+			if err != nil {
+				iuc.ErrLog.Printf("checking for image updates: %s: %v", reference.FamiliarString(tagged), err)
+			}
+			remote[key] = digest
+		}
+		if digest == "" {
+			continue
+		}
+		digests, ok := repoDigests[ci.Image]
+		if !ok {
+			if img, _, err := cli.ImageInspectWithRaw(ctx, ci.Image); err == nil {
+				digests = img.RepoDigests
+			}
+			repoDigests[ci.Image] = digests
+		}
+		if len(digests) > 0 && !containsString(digests, reference.FamiliarName(tagged)+"@"+digest) {
+			outdated[c.ID] = true
+		}
+	}
+
+	iuc.mu.Lock()
+	changed := len(outdated) != len(iuc.outdated)
+	for id := range outdated {
+		changed = changed || !iuc.outdated[id]
+	}
+	iuc.outdated = outdated
+	iuc.mu.Unlock()
+	if changed {
+		listCache.Invalidate()
+	}
+	return nil
+}
+
+// remoteDigest returns the digest of the manifest the tag points at in its
+// registry.
+func (iuc *ImageUpdateChecker) remoteDigest(ctx context.Context, ref reference.NamedTagged) (string, error) {
+	domain := reference.Domain(ref)
+	host := domain
+	if domain == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if containsString(iuc.InsecureRegistries, domain) {
+		scheme = "http"
+	}
+	u := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, reference.Path(ref), ref.Tag())
+
+	resp, err := iuc.manifestHead(ctx, u, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := iuc.authorization(ctx, resp.Header.Get("WWW-Authenticate"), domain, reference.Path(ref))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = iuc.manifestHead(ctx, u, authorization); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry responded %s", resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry responded without a digest")
+	}
+	return digest, nil
+}
+
+func (iuc *ImageUpdateChecker) manifestHead(ctx context.Context, u, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := iuc.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// authorization answers the challenge of a registry with the stored
+// credentials for it, or anonymously when there are none.
+func (iuc *ImageUpdateChecker) authorization(ctx context.Context, challenge, domain, repository string) (string, error) {
+	cred, hasCred := registries.lookup(domain)
+	scheme, params := parseAuthChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "basic") && hasCred:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(cred.Username+":"+cred.Password)), nil
+	case !strings.EqualFold(scheme, "bearer") || params["realm"] == "":
+		return "", fmt.Errorf("registry requires %s authentication", scheme)
+	}
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	q.Set("scope", scope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := iuc.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service responded %s", resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	return "Bearer " + t.Token, nil
+}
+
+// parseAuthChallenge splits a WWW-Authenticate header such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+// into its scheme and parameters.
+func parseAuthChallenge(h string) (string, map[string]string) {
+	params := map[string]string{}
+	scheme, rest := h, ""
+	if i := strings.IndexByte(h, ' '); i >= 0 {
+		scheme, rest = h[:i], h[i+1:]
+	}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		i := strings.IndexByte(rest, '=')
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:i]))
+		rest = rest[i+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// testRegistry serves the manifest of team/app:1.0 to requests with a
+// token from its token service, which requires basic authentication when
+// user is set.
+func testRegistry(t *testing.T, user, password string) *httptest.Server {
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("scope"); got != "repository:team/app:pull" {
+			t.Errorf("token scope %q", got)
+		}
+		if u, p, ok := r.BasicAuth(); user != "" && (!ok || u != user || p != password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+	})
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("manifest requested with %s", r.Method)
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.list.v2+json") {
+			t.Errorf("manifest lists not accepted: %q", r.Header.Get("Accept"))
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test-registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.TrimPrefix(r.URL.Path, "/v2/team/app/manifests/") != "1.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", testDigest)
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testChecker(srv *httptest.Server) *ImageUpdateChecker {
+	return &ImageUpdateChecker{
+		Interval:           time.Hour,
+		InsecureRegistries: []string{strings.TrimPrefix(srv.URL, "http://")},
+		Client:             srv.Client(),
+		ErrLog:             log.New(ioutil.Discard, "", 0),
+	}
+}
+
+func testRef(t *testing.T, srv *httptest.Server, tag string) reference.NamedTagged {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(srv.URL, "http://") + "/team/app:" + tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return named.(reference.NamedTagged)
+}
+
+// useRegistries stores the credential in a temporary registry store for the
+// duration of the test.
+func useRegistries(t *testing.T, c registryCredential) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	rs, err := NewRegistryStore(filepath.Join(t.TempDir(), "registries"), base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.put(c); err != nil {
+		t.Fatal(err)
+	}
+	registries = rs
+	t.Cleanup(func() { registries = nil })
+}
+
+func TestRemoteDigestAnonymous(t *testing.T) {
+	srv := testRegistry(t, "", "")
+	iuc := testChecker(srv)
+	digest, err := iuc.remoteDigest(context.Background(), testRef(t, srv, "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Errorf("digest %q", digest)
+	}
+	if _, err := iuc.remoteDigest(context.Background(), testRef(t, srv, "2.0")); err == nil {
+		t.Error("unknown tag gave no error")
+	}
+}
+
+func TestRemoteDigestStoredCredentials(t *testing.T) {
+	srv := testRegistry(t, "ci", "s3cret")
+	iuc := testChecker(srv)
+	if _, err := iuc.remoteDigest(context.Background(), testRef(t, srv, "1.0")); err == nil {
+		t.Fatal("token issued without credentials")
+	}
+
+	useRegistries(t, registryCredential{Server: strings.TrimPrefix(srv.URL, "http://"), Username: "ci", Password: "s3cret"})
+	digest, err := iuc.remoteDigest(context.Background(), testRef(t, srv, "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != testDigest {
+		t.Errorf("digest %q", digest)
+	}
+}
+
+func TestAuthorizationBasic(t *testing.T) {
+	iuc := &ImageUpdateChecker{Client: http.DefaultClient}
+	if _, err := iuc.authorization(context.Background(), `Basic realm="registry"`, "registry.example.com", "team/app"); err == nil {
+		t.Error("basic challenge answered without credentials")
+	}
+
+	useRegistries(t, registryCredential{Server: "registry.example.com", Username: "ci", Password: "s3cret"})
+	got, err := iuc.authorization(context.Background(), `Basic realm="registry"`, "registry.example.com", "team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("ci:s3cret")); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`, "Bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"}},
+		{`Bearer realm="https://r.example.com/token", service="r", scope="repository:a/b:pull,push"`, "Bearer",
+			map[string]string{"realm": "https://r.example.com/token", "service": "r", "scope": "repository:a/b:pull,push"}},
+		{`Basic realm=registry`, "Basic", map[string]string{"realm": "registry"}},
+		{`Basic`, "Basic", map[string]string{}},
+	}
+	for _, tt := range tests {
+		scheme, params := parseAuthChallenge(tt.header)
+		if scheme != tt.scheme {
+			t.Errorf("%s: scheme %q", tt.header, scheme)
+		}
+		if len(params) != len(tt.params) {
+			t.Errorf("%s: params %v", tt.header, params)
+		}
+		for k, v := range tt.params {
+			if params[k] != v {
+				t.Errorf("%s: %s is %q, want %q", tt.header, k, params[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxListLimit = 500
+
+// listItem is an entry of a list response reduced to what sorting and
+// pagination need.
+type listItem struct {
+	ID  string
+	Key string
+}
+
+// listCursor points at the last item of a page, the next page starts after
+// it. It is sent to clients base64 encoded.
+type listCursor struct {
+	Key string `json:"k"`
+	ID  string `json:"id"`
+}
+
+// listOptions are the sort, cursor and limit parameters of a list request.
+// Sort is a key, prefixed with - for descending order.
+type listOptions struct {
+	sortKey string
+	desc    bool
+	cursor  *listCursor
+	limit   int
+}
+
+func parseListOptions(r *http.Request, sortKeys []string, defaultSort string) (listOptions, error) {
+	q := r.URL.Query()
+	lo := listOptions{}
+	sortParam := q.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	lo.sortKey = strings.TrimPrefix(sortParam, "-")
+	lo.desc = lo.sortKey != sortParam
+	if !containsString(sortKeys, lo.sortKey) {
+		return lo, newBadRequestError(fmt.Sprintf("unknown sort key %q, use one of %s", lo.sortKey, strings.Join(sortKeys, ", ")))
+	}
+	if c := q.Get("cursor"); c != "" {
+		b, err := base64.RawURLEncoding.DecodeString(c)
+		if err == nil {
+			err = json.Unmarshal(b, &lo.cursor)
+		}
+		if err != nil || lo.cursor == nil {
+			return lo, newBadRequestError("invalid cursor")
+		}
+	}
+	var err error
+	if lo.limit, err = queryLimit(r, 0); err != nil {
+		return lo, err
+	}
+	if lo.limit < 0 || lo.limit > maxListLimit {
+		return lo, newBadRequestError(fmt.Sprintf("limit must be between 0 and %d", maxListLimit))
+	}
+	return lo, nil
+}
+
+func (lo listOptions) less(a, b listItem) bool {
+	if lo.desc {
+		a, b = b, a
+	}
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.ID < b.ID
+}
+
+// page sorts items and returns those after the cursor, up to the limit, and
+// the cursor of the next page, empty on the last page.
+func (lo listOptions) page(items []listItem) ([]listItem, string) {
+	sort.Slice(items, func(i, j int) bool { return lo.less(items[i], items[j]) })
+	if lo.cursor != nil {
+		after := listItem{ID: lo.cursor.ID, Key: lo.cursor.Key}
+		i := sort.Search(len(items), func(i int) bool { return lo.less(after, items[i]) })
+		items = items[i:]
+	}
+	if lo.limit == 0 || len(items) <= lo.limit {
+		return items, ""
+	}
+	items = items[:lo.limit]
+	last := items[len(items)-1]
+	b, _ := json.Marshal(listCursor{Key: last.Key, ID: last.ID})
+	return items, base64.RawURLEncoding.EncodeToString(b)
+}
+
+// setNextLink adds a Link header pointing at the next page, keeping the other
+// query parameters of the request.
+func setNextLink(w http.ResponseWriter, r *http.Request, links Linker, route, cursor string) {
+	if cursor == "" {
+		return
+	}
+	link := links.Link(r, route, "next", "GET")
+	if link == nil {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	w.Header().Set("Link", "<"+link.Href+"?"+q.Encode()+">; rel=\"next\"")
+}
+
+// timeSortKey formats t so that keys sort in time order.
+func timeSortKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+func unixSortKey(sec int64) string {
+	return timeSortKey(time.Unix(sec, 0))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listCache holds the container and service list responses for a short
+// while, the UI polls them every second. It is replaced at startup with the
+// configured TTL.
+var listCache = newResponseCache(0)
+
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: map[string]cachedResponse{}}
+}
+
+func (rc *responseCache) get(key string) (cachedResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return e, false
+	}
+	return e, true
+}
+
+func (rc *responseCache) put(key string, e cachedResponse) {
+	if rc.ttl <= 0 {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	for k, old := range rc.entries {
+		if now.After(old.expires) {
+			delete(rc.entries, k)
+		}
+	}
+	e.expires = now.Add(rc.ttl)
+	rc.entries[key] = e
+}
+
+// Invalidate drops all responses, called after actions changing the lists.
+func (rc *responseCache) Invalidate() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries = map[string]cachedResponse{}
+}
+
+// bufferedResponse collects the response of a handler so it can be cached.
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	return br.header
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	return br.body.Write(b)
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {}
+
+// cachedList serves the response of fn from listCache while it is fresh and
+// answers If-None-Match with 304 Not Modified. Responses depend on the
+// subject, for authorization, and on the headers links are built from.
+func cachedList(auth Authenticator, fn func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		key := strings.Join([]string{auth.Subject(r), r.URL.String(), r.Host,
+			r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Prefix")}, "\xff")
+		resp, ok := listCache.get(key)
+		if !ok {
+			br := &bufferedResponse{header: http.Header{}}
+			if err := fn(br, r); err != nil {
+				return err
+			}
+			sum := sha256.Sum256(br.body.Bytes())
+			resp = cachedResponse{header: br.header, body: br.body.Bytes(), etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+			listCache.put(key, resp)
+		}
+		for k, v := range resp.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", resp.etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(resp.body))
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	timetypes "github.com/docker/docker/api/types/time"
+)
+
+// LogExportRequest selects the logs to export, either by container and
+// service IDs or by labels, given as key or key=value.
+type LogExportRequest struct {
+	Containers []string `json:"containers"`
+	Services   []string `json:"services"`
+	Labels     []string `json:"labels"`
+	// Since and Until are durations before now, such as 1h, or RFC 3339 or
+	// Unix timestamps.
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+func parseTimeParam(name, value string) (time.Time, error) {
+	ts, err := timetypes.GetTimestamp(value, time.Now())
+	if err == nil {
+		var sec, nsec int64
+		if sec, nsec, err = timetypes.ParseTimestamps(ts, 0); err == nil {
+			return time.Unix(sec, nsec), nil
+		}
+	}
+	return time.Time{}, newBadRequestError(fmt.Sprintf("invalid %s %q", name, value))
+}
+
+// ExportLogs streams a zip archive holding the log and an inspect snapshot
+// of each selected container and service. The archive is written as the
+// logs are read, a log that fails part way is followed by an error file.
+func ExportLogs(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		var req LogExportRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			return newBadRequestError(fmt.Sprintf("invalid export request: %v", err))
+		}
+		byID := len(req.Containers)+len(req.Services) > 0
+		if byID == (len(req.Labels) > 0) {
+			return newBadRequestError("select logs either by containers and services or by labels")
+		}
+		var until time.Time
+		if req.Since != "" {
+			if _, err := parseTimeParam("since", req.Since); err != nil {
+				return err
+			}
+		}
+		if req.Until != "" {
+			var err error
+			if until, err = parseTimeParam("until", req.Until); err != nil {
+				return err
+			}
+		}
+
+		ctx, done := streams.Context(r)
+		defer done()
+
+		var targets []logTarget
+		var err error
+		if byID {
+			targets, err = requestedLogTargets(ctx, auth, r, req.Containers, req.Services)
+		} else {
+			f := filters.NewArgs()
+			for _, l := range req.Labels {
+				f.Add("label", l)
+			}
+			targets, err = authorizedLogTargets(ctx, auth, r, f)
+		}
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"logs-"+time.Now().UTC().Format("20060102T150405Z")+".zip\"")
+		zw := zip.NewWriter(w)
+		for _, t := range targets {
+			base := t.Type + "s/" + t.Name
+			var errs []error
+			if err := exportInspect(ctx, zw, base+".json", t); err != nil {
+				errs = append(errs, err)
+			}
+			if err := exportLog(ctx, zw, base+".log", t, req.Since, until); err != nil {
+				errs = append(errs, err)
+			}
+			if ctx.Err() != nil {
+				// The client went away, there is no one to report to.
+				return nil
+			}
+			if len(errs) > 0 {
+				f, err := createZipFile(zw, base+".error.txt")
+				if err != nil {
+					return err
+				}
+				for _, err := range errs {
+					fmt.Fprintln(f, err)
+				}
+			}
+		}
+		return zw.Close()
+	}
+}
+
+func exportInspect(ctx context.Context, zw *zip.Writer, name string, t logTarget) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	var raw []byte
+	if t.Type == logTargetService {
+		_, raw, err = cli.ServiceInspectWithRaw(ctx, t.ID)
+	} else {
+		_, raw, err = cli.ContainerInspectWithRaw(ctx, t.ID, false)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := createZipFile(zw, name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(raw)
+	return err
+}
+
+// exportLog writes the log of t with timestamps. Logs are in time order, so
+// reading stops at the first line after until.
+func exportLog(ctx context.Context, zw *zip.Writer, name string, t logTarget, since string, until time.Time) error {
+	reader, err := t.Logs(ctx, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Since: since, Timestamps: true})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	f, err := createZipFile(zw, name)
+	if err != nil {
+		return err
+	}
+	scanner := newLogScanner(reader, t.TTY, true)
+	for scanner.Scan() {
+		line := scanner.Line()
+		if !until.IsZero() && line.Time.After(until) {
+			return nil
+		}
+		if !line.Time.IsZero() {
+			io.WriteString(f, line.Time.Format(time.RFC3339Nano)+" ")
+		}
+		if _, err := io.WriteString(f, line.Text+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func createZipFile(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// logFieldFilter matches JSON log lines with a field, given as a dotted path
+// such as http.status, holding value.
+type logFieldFilter struct {
+	path  string
+	value string
+}
+
+// logFields controls parsing of JSON log lines.
+type logFields struct {
+	// parse adds the fields of JSON lines to the entries.
+	parse   bool
+	filters []logFieldFilter
+	// project limits the fields added to these paths.
+	project []string
+}
+
+// parseLogFields reads the json, field and fields parameters. Every field
+// parameter, such as field=level=error, must match for a line to be kept and
+// drops all lines that are not JSON.
+func parseLogFields(q url.Values) (logFields, error) {
+	lf := logFields{parse: q.Get("json") == "true", project: splitList(q.Get("fields"))}
+	for _, f := range q["field"] {
+		i := strings.IndexByte(f, '=')
+		if i < 1 {
+			return lf, newBadRequestError(fmt.Sprintf("field filter %q is not given as name=value", f))
+		}
+		lf.filters = append(lf.filters, logFieldFilter{path: f[:i], value: f[i+1:]})
+	}
+	if len(lf.project) > 0 {
+		lf.parse = true
+	}
+	return lf, nil
+}
+
+func (lf logFields) enabled() bool {
+	return lf.parse || len(lf.filters) > 0
+}
+
+// apply parses the line of e when needed and reports whether it passes the
+// filters.
+func (lf logFields) apply(e *LogEntry) bool {
+	if !lf.enabled() {
+		return true
+	}
+	fields, ok := parseJSONLog(e.Line)
+	if !ok {
+		return len(lf.filters) == 0
+	}
+	for _, f := range lf.filters {
+		v, ok := lookupField(fields, f.path)
+		if !ok || fieldString(v) != f.value {
+			return false
+		}
+	}
+	if !lf.parse {
+		return true
+	}
+	if len(lf.project) == 0 {
+		e.Fields = fields
+		return true
+	}
+	e.Fields = map[string]interface{}{}
+	for _, p := range lf.project {
+		if v, ok := lookupField(fields, p); ok {
+			e.Fields[p] = v
+		}
+	}
+	return true
+}
+
+// parseJSONLog returns the fields of a line holding a JSON object.
+func parseJSONLog(line string) (map[string]interface{}, bool) {
+	s := strings.TrimSpace(line)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, false
+	}
+	var fields map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// lookupField finds a field by a dotted path, either as a key holding dots,
+// as some loggers write them, or through nested objects.
+func lookupField(fields map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := fields[path]; ok {
+		return v, true
+	}
+	i := strings.IndexByte(path, '.')
+	if i < 0 {
+		return nil, false
+	}
+	nested, ok := fields[path[:i]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(nested, path[i+1:])
+}
+
+// fieldString formats a field value for comparison and CSV columns.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
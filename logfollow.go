@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	timetypes "github.com/docker/docker/api/types/time"
+)
+
+const (
+	maxFollowTargets = 20
+	// logMergeWindow is how long lines are held back so that lines other
+	// targets wrote at about the same time can be put in order with them.
+	logMergeWindow = 250 * time.Millisecond
+)
+
+type logFollow struct {
+	since string
+	tail  string
+	// level and pattern, when set, must both match a line for it to be sent.
+	level   *regexp.Regexp
+	pattern *regexp.Regexp
+	fields  logFields
+}
+
+func parseLogFollow(r *http.Request) (logFollow, error) {
+	q := r.URL.Query()
+	lf := logFollow{since: q.Get("since"), tail: q.Get("tail")}
+	if n := len(q["container"]) + len(q["service"]); n == 0 || n > maxFollowTargets {
+		return lf, newBadRequestError(fmt.Sprintf("give between 1 and %d containers or services", maxFollowTargets))
+	}
+	if lf.since != "" {
+		if _, err := timetypes.GetTimestamp(lf.since, time.Now()); err != nil {
+			return lf, newBadRequestError(fmt.Sprintf("invalid since %q", lf.since))
+		}
+	}
+	if lf.tail == "" {
+		lf.tail = "100"
+	}
+	var err error
+	if lf.fields, err = parseLogFields(q); err != nil {
+		return lf, err
+	}
+	if levels := splitList(q.Get("level")); len(levels) > 0 {
+		for i, l := range levels {
+			levels[i] = regexp.QuoteMeta(l)
+		}
+		lf.level = regexp.MustCompile(`(?i)\b(?:` + strings.Join(levels, "|") + `)\b`)
+	}
+	if lf.pattern, err = queryPattern(q); err != nil {
+		return lf, err
+	}
+	return lf, nil
+}
+
+func (lf logFollow) match(text string) bool {
+	return (lf.level == nil || lf.level.MatchString(text)) && (lf.pattern == nil || lf.pattern.MatchString(text))
+}
+
+// FollowLogs follows the logs of the containers and services given by the
+// container and service parameters and streams them as one timeline of
+// newline delimited JSON.
+func FollowLogs(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		follow, err := parseLogFollow(r)
+		if err != nil {
+			return err
+		}
+		ctx, done := streams.Context(r)
+		defer done()
+
+		q := r.URL.Query()
+		targets, err := requestedLogTargets(ctx, auth, r, q["container"], q["service"])
+		if err != nil {
+			return err
+		}
+
+		nw := newNDJSONWriter(w)
+		entries := make(chan LogEntry)
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Add(1)
+			go func(t logTarget) {
+				defer wg.Done()
+				if err := follow.run(ctx, t, entries); err != nil {
+					select {
+					case entries <- LogEntry{TargetType: t.Type, ID: t.ID, Name: t.Name, Error: err.Error()}:
+					case <-ctx.Done():
+					}
+				}
+			}(t)
+		}
+		go func() {
+			wg.Wait()
+			close(entries)
+		}()
+		mergeLogEntries(ctx, entries, func(e LogEntry) { nw.Write(e) })
+		return nil
+	}
+}
+
+func (lf logFollow) run(ctx context.Context, t logTarget, entries chan<- LogEntry) error {
+	rc, err := t.Logs(ctx, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Since: lf.since, Tail: lf.tail, Follow: true, Timestamps: true})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	scanner := newLogScanner(rc, t.TTY, true)
+	for scanner.Scan() {
+		line := scanner.Line()
+		if !lf.match(line.Text) {
+			continue
+		}
+		e := newLogEntry(t, line)
+		if !lf.fields.apply(&e) {
+			continue
+		}
+		select {
+		case entries <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+type pendingLogEntry struct {
+	entry   LogEntry
+	time    time.Time
+	arrived time.Time
+}
+
+// logEntryHeap orders pending entries by the time they were logged.
+type logEntryHeap []pendingLogEntry
+
+func (h logEntryHeap) Len() int            { return len(h) }
+func (h logEntryHeap) Less(i, j int) bool  { return h[i].time.Before(h[j].time) }
+func (h logEntryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *logEntryHeap) Push(x interface{}) { *h = append(*h, x.(pendingLogEntry)) }
+func (h *logEntryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// mergeLogEntries sends the entries in the order they were logged. Followed
+// logs never end, so an entry is held back for logMergeWindow after it
+// arrived; entries arriving later than that are sent out of order.
+func mergeLogEntries(ctx context.Context, entries <-chan LogEntry, send func(LogEntry)) {
+	ticker := time.NewTicker(logMergeWindow / 5)
+	defer ticker.Stop()
+	pending := &logEntryHeap{}
+	release := func(all bool) {
+		cutoff := time.Now().Add(-logMergeWindow)
+		for pending.Len() > 0 && (all || !(*pending)[0].arrived.After(cutoff)) {
+			send(heap.Pop(pending).(pendingLogEntry).entry)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-entries:
+			if !ok {
+				release(true)
+				return
+			}
+			now := time.Now()
+			p := pendingLogEntry{entry: e, time: now, arrived: now}
+			if e.Time != nil {
+				p.time = *e.Time
+			}
+			heap.Push(pending, p)
+		case <-ticker.C:
+			release(false)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// maxLogLineLength truncates longer lines, protecting against containers
+// writing huge amounts of data without a newline.
+const maxLogLineLength = 64 * 1024
+
+var logStreamNames = [...]string{"stdin", "stdout", "stderr"}
+
+type logLine struct {
+	Stream string
+	// Time is only set when the logs were requested with timestamps.
+	Time time.Time
+	Text string
+}
+
+// logScanner reads a Docker log stream line by line. Unless the container
+// has a TTY the stream is multiplexed, every frame starting with an 8 byte
+// header holding the stream and the frame size. Lines may be split across
+// frames.
+type logScanner struct {
+	r          io.Reader
+	tty        bool
+	timestamps bool
+
+	frame   []byte
+	partial [len(logStreamNames)][]byte
+	ready   []logLine
+	line    logLine
+	err     error
+}
+
+func newLogScanner(r io.Reader, tty, timestamps bool) *logScanner {
+	return &logScanner{r: r, tty: tty, timestamps: timestamps}
+}
+
+func (ls *logScanner) Scan() bool {
+	for len(ls.ready) == 0 {
+		if ls.err != nil {
+			return false
+		}
+		ls.fill()
+	}
+	ls.line, ls.ready = ls.ready[0], ls.ready[1:]
+	return true
+}
+
+func (ls *logScanner) Line() logLine {
+	return ls.line
+}
+
+func (ls *logScanner) Err() error {
+	if ls.err == io.EOF {
+		return nil
+	}
+	return ls.err
+}
+
+func (ls *logScanner) fill() {
+	stream, err := ls.readFrame()
+	if err != nil {
+		if err == io.EOF {
+			// Lines not ended by a newline are still lines.
+			for s := range ls.partial {
+				if len(ls.partial[s]) > 0 {
+					ls.emit(s)
+				}
+			}
+		}
+		ls.err = err
+		return
+	}
+	for b := ls.frame; len(b) > 0; {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			ls.append(stream, b)
+			break
+		}
+		ls.append(stream, b[:i])
+		ls.emit(stream)
+		b = b[i+1:]
+	}
+}
+
+func (ls *logScanner) readFrame() (int, error) {
+	if ls.tty {
+		if ls.frame == nil {
+			ls.frame = make([]byte, 32*1024)
+		}
+		ls.frame = ls.frame[:cap(ls.frame)]
+		n, err := ls.r.Read(ls.frame)
+		ls.frame = ls.frame[:n]
+		if n > 0 {
+			return 1, nil
+		}
+		return 0, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(ls.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, errors.New("log stream ended within a frame header")
+		}
+		return 0, err
+	}
+	stream := int(header[0])
+	if stream >= len(logStreamNames) {
+		return 0, fmt.Errorf("invalid log stream %d", stream)
+	}
+	size := int(binary.BigEndian.Uint32(header[4:]))
+	if cap(ls.frame) < size {
+		ls.frame = make([]byte, size)
+	}
+	ls.frame = ls.frame[:size]
+	if _, err := io.ReadFull(ls.r, ls.frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return stream, nil
+}
+
+func (ls *logScanner) append(stream int, b []byte) {
+	if room := maxLogLineLength - len(ls.partial[stream]); len(b) > room {
+		b = b[:room]
+	}
+	ls.partial[stream] = append(ls.partial[stream], b...)
+}
+
+func (ls *logScanner) emit(stream int) {
+	text := string(bytes.TrimSuffix(ls.partial[stream], []byte("\r")))
+	ls.partial[stream] = ls.partial[stream][:0]
+	line := logLine{Stream: logStreamNames[stream], Text: text}
+	if ls.timestamps {
+		if i := strings.IndexByte(text, ' '); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, text[:i]); err == nil {
+				line.Time, line.Text = t, text[i+1:]
+			}
+		}
+	}
+	ls.ready = append(ls.ready, line)
+}
+
+// LogEntry is a log line of a container or service, or the error ending its
+// log when Error is set.
+type LogEntry struct {
+	TargetType string     `json:"targetType"`
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Time       *time.Time `json:"time,omitempty"`
+	Stream     string     `json:"stream,omitempty"`
+	Line       string     `json:"line,omitempty"`
+	// Fields holds the parsed fields of a JSON line, when asked for.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+func newLogEntry(t logTarget, line logLine) LogEntry {
+	e := LogEntry{TargetType: t.Type, ID: t.ID, Name: t.Name, Stream: line.Stream, Line: line.Text}
+	if !line.Time.IsZero() {
+		ts := line.Time
+		e.Time = &ts
+	}
+	return e
+}
+
+const (
+	logFormatText   = "text"
+	logFormatNDJSON = "ndjson"
+	logFormatCSV    = "csv"
+)
+
+// downloadLog writes the whole log of t as an attachment, as plain text or,
+// with the format parameter, as newline delimited JSON or CSV. JSON lines can
+// be filtered and their fields projected, see parseLogFields.
+func downloadLog(ctx context.Context, t logTarget, w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = logFormatText
+	}
+	fields, err := parseLogFields(q)
+	if err != nil {
+		return err
+	}
+	var contentType, ext string
+	switch format {
+	case logFormatText:
+		contentType, ext = "text/plain;charset=UTF-8", ".log"
+	case logFormatNDJSON:
+		contentType, ext = "application/x-ndjson", ".ndjson"
+	case logFormatCSV:
+		contentType, ext = "text/csv;charset=UTF-8", ".csv"
+	default:
+		return newBadRequestError(fmt.Sprintf("unknown format %q, use text, ndjson or csv", format))
+	}
+
+	reader, err := t.Logs(ctx, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+t.Name+ext+"\"")
+
+	var write func(e LogEntry) error
+	switch format {
+	case logFormatText:
+		write = func(e LogEntry) error {
+			_, err := io.WriteString(w, e.Line+"\n")
+			return err
+		}
+	case logFormatNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(e LogEntry) error { return enc.Encode(e) }
+	case logFormatCSV:
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		header := []string{"time", "stream", "line"}
+		if len(fields.project) > 0 {
+			header = append(header[:2], fields.project...)
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		write = func(e LogEntry) error {
+			row := []string{"", e.Stream, e.Line}
+			if e.Time != nil {
+				row[0] = e.Time.Format(time.RFC3339Nano)
+			}
+			if len(fields.project) > 0 {
+				row = row[:2]
+				for _, p := range fields.project {
+					row = append(row, fieldString(e.Fields[p]))
+				}
+			}
+			return cw.Write(row)
+		}
+	}
+
+	scanner := newLogScanner(reader, t.TTY, true)
+	for scanner.Scan() {
+		e := newLogEntry(t, scanner.Line())
+		if !fields.apply(&e) {
+			continue
+		}
+		if err := write(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ndjsonWriter writes values as newline delimited JSON, flushing after each
+// so the client sees them as they are produced. It is safe for concurrent
+// use.
+type ndjsonWriter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	flusher http.Flusher
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	return &ndjsonWriter{enc: json.NewEncoder(w), flusher: flusher}
+}
+
+func (nw *ndjsonWriter) Write(v interface{}) error {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+	if nw.flusher != nil {
+		nw.flusher.Flush()
+	}
+	return nil
+}
+
+const (
+	logTargetContainer = "container"
+	logTargetService   = "service"
+)
+
+// logTarget is a container or service whose logs can be read.
+type logTarget struct {
+	Type string
+	ID   string
+	Name string
+	TTY  bool
+}
+
+func (t logTarget) Logs(ctx context.Context, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	if t.Type == logTargetService {
+		return cli.ServiceLogs(ctx, t.ID, options)
+	}
+	return cli.ContainerLogs(ctx, t.ID, options)
+}
+
+// authorizedLogTargets returns the containers, and services when the daemon
+// is a swarm manager, matching f that the caller is allowed to read logs of.
+func authorizedLogTargets(ctx context.Context, auth Authenticator, r *http.Request, f filters.Args) ([]logTarget, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: f})
+	if err != nil {
+		return nil, err
+	}
+	targets := []logTarget{}
+	for _, c := range cs {
+		allowed, err := auth.IsContainerAllowed(r, c.ID)
+		if err != nil {
+			if classifyError(err).Status == http.StatusNotFound {
+				// Removed since it was listed.
+				continue
+			}
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+		ci, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			if classifyError(err).Status == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		targets = append(targets, logTarget{Type: logTargetContainer, ID: c.ID, Name: ci.Name[1:], TTY: ci.Config != nil && ci.Config.Tty})
+	}
+
+	// Listing services fails unless the daemon is a swarm manager, in which
+	// case there are no service logs to read.
+	svcs, err := cli.ServiceList(ctx, types.ServiceListOptions{Filters: f})
+	if err != nil {
+		return targets, nil
+	}
+	for _, svc := range svcs {
+		allowed, err := auth.IsServiceAllowed(r, svc.ID)
+		if err != nil {
+			if classifyError(err).Status == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		if allowed {
+			targets = append(targets, logTarget{Type: logTargetService, ID: svc.ID, Name: svc.Spec.Name})
+		}
+	}
+	return targets, nil
+}
+
+// requestedLogTargets returns the given containers and services, failing
+// unless the caller is allowed to read the logs of all of them.
+func requestedLogTargets(ctx context.Context, auth Authenticator, r *http.Request, containerIDs, serviceIDs []string) ([]logTarget, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	targets := []logTarget{}
+	for _, id := range containerIDs {
+		allowed, err := auth.IsContainerAllowed(r, id)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errForbidden
+		}
+		ci, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, logTarget{Type: logTargetContainer, ID: ci.ID, Name: ci.Name[1:], TTY: ci.Config != nil && ci.Config.Tty})
+	}
+	for _, id := range serviceIDs {
+		allowed, err := auth.IsServiceAllowed(r, id)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errForbidden
+		}
+		svc, _, err := cli.ServiceInspectWithRaw(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, logTarget{Type: logTargetService, ID: svc.ID, Name: svc.Spec.Name})
+	}
+	return targets, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	timetypes "github.com/docker/docker/api/types/time"
+)
+
+const maxLogSearchContext = 20
+
+// LogMatch is a line matching a log search with its surrounding lines.
+type LogMatch struct {
+	LogEntry
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type logSearch struct {
+	pattern    *regexp.Regexp
+	since      string
+	context    int
+	maxMatches int
+}
+
+func parseLogSearch(r *http.Request) (logSearch, error) {
+	q := r.URL.Query()
+	ls := logSearch{since: q.Get("since"), context: 2, maxMatches: 100}
+	if q.Get("q") == "" {
+		return ls, newBadRequestError("q is required")
+	}
+	var err error
+	if ls.pattern, err = queryPattern(q); err != nil {
+		return ls, err
+	}
+	if ls.since != "" {
+		if _, err := timetypes.GetTimestamp(ls.since, time.Now()); err != nil {
+			return ls, newBadRequestError(fmt.Sprintf("invalid since %q", ls.since))
+		}
+	}
+	if c := q.Get("context"); c != "" {
+		if ls.context, err = strconv.Atoi(c); err != nil || ls.context < 0 || ls.context > maxLogSearchContext {
+			return ls, newBadRequestError(fmt.Sprintf("context must be between 0 and %d lines", maxLogSearchContext))
+		}
+	}
+	if ls.maxMatches, err = queryLimit(r, ls.maxMatches); err != nil {
+		return ls, err
+	}
+	return ls, nil
+}
+
+// queryPattern compiles the q parameter, matched literally unless regex=true
+// and case-insensitive with ignoreCase=true. It is nil when q is not given.
+func queryPattern(q url.Values) (*regexp.Regexp, error) {
+	expr := q.Get("q")
+	if expr == "" {
+		return nil, nil
+	}
+	if q.Get("regex") != "true" {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if q.Get("ignoreCase") == "true" {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, newBadRequestError(fmt.Sprintf("invalid regular expression: %v", err))
+	}
+	return re, nil
+}
+
+// SearchLogs scans the logs of all containers and services the caller is
+// allowed to read, concurrency at a time. Matches are streamed as
+// newline delimited JSON as soon as their trailing context has been read.
+func SearchLogs(auth Authenticator, concurrency int) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		search, err := parseLogSearch(r)
+		if err != nil {
+			return err
+		}
+		ctx, done := streams.Context(r)
+		defer done()
+
+		targets, err := authorizedLogTargets(ctx, auth, r, filters.NewArgs())
+		if err != nil {
+			return err
+		}
+
+		nw := newNDJSONWriter(w)
+		send := func(m LogMatch) { nw.Write(m) }
+
+		limit := &matchLimit{max: int64(search.maxMatches)}
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, t := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(t logTarget) {
+				defer func() { <-sem; wg.Done() }()
+				if err := search.run(ctx, t, limit, send); err != nil && ctx.Err() == nil {
+					send(LogMatch{LogEntry: LogEntry{TargetType: t.Type, ID: t.ID, Name: t.Name, Error: err.Error()}})
+				}
+			}(t)
+		}
+		wg.Wait()
+		return nil
+	}
+}
+
+// matchLimit counts the matches of a search across all its targets, max 0
+// is no limit.
+type matchLimit struct {
+	max int64
+	n   int64
+}
+
+// take reserves a match, it returns false when the limit has been reached.
+func (ml *matchLimit) take() bool {
+	return ml.max <= 0 || atomic.AddInt64(&ml.n, 1) <= ml.max
+}
+
+func (ml *matchLimit) reached() bool {
+	return ml.max > 0 && atomic.LoadInt64(&ml.n) >= ml.max
+}
+
+func (ls logSearch) run(ctx context.Context, t logTarget, limit *matchLimit, send func(LogMatch)) error {
+	rc, err := t.Logs(ctx, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Since: ls.since, Timestamps: true})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var before []string
+	var pending []*LogMatch
+	scanner := newLogScanner(rc, t.TTY, true)
+	for scanner.Scan() {
+		line := scanner.Line()
+		kept := pending[:0]
+		for _, m := range pending {
+			m.After = append(m.After, line.Text)
+			if len(m.After) == ls.context {
+				send(*m)
+			} else {
+				kept = append(kept, m)
+			}
+		}
+		pending = kept
+		if limit.reached() {
+			if len(pending) == 0 {
+				return nil
+			}
+		} else if ls.pattern.MatchString(line.Text) && limit.take() {
+			m := &LogMatch{LogEntry: newLogEntry(t, line), Before: append([]string{}, before...)}
+			if ls.context == 0 {
+				send(*m)
+			} else {
+				pending = append(pending, m)
+			}
+		}
+		if ls.context > 0 {
+			if len(before) == ls.context {
+				before = append(before[:0], before[1:]...)
+			}
+			before = append(before, line.Text)
+		}
+	}
+	for _, m := range pending {
+		send(*m)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics holds the metrics of conman itself, exposed in the Prometheus text
+// format on /metrics.
+var metrics = NewMetrics()
+
+type Metrics struct {
+	HTTPRequests   *counterVec
+	HTTPDuration   *histogramVec
+	DockerDuration *histogramVec
+	DockerErrors   *counterVec
+
+	mu         sync.Mutex
+	collectors []func(w io.Writer)
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		HTTPRequests:   newCounterVec("conman_http_requests_total", "HTTP requests served by route, method and status code.", "route", "method", "code"),
+		HTTPDuration:   newHistogramVec("conman_http_request_duration_seconds", "Time to serve HTTP requests by route and method.", defaultBuckets, "route", "method"),
+		DockerDuration: newHistogramVec("conman_docker_request_duration_seconds", "Latency of Docker API calls by method and operation.", defaultBuckets, "method", "operation"),
+		DockerErrors:   newCounterVec("conman_docker_request_errors_total", "Docker API calls that failed or returned an error status.", "method", "operation"),
+	}
+}
+
+// Register adds a function writing metric families computed at scrape time.
+func (m *Metrics) Register(collect func(w io.Writer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, collect)
+}
+
+// metricsAuth only serves requests with the bearer token, when one is set,
+// or of admins, as the metrics name containers and the subjects managing
+// them.
+func metricsAuth(auth Authenticator, token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !auth.HasRole(r, RoleAdmin) {
+			writeError(w, r, errForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.HTTPRequests.write(bw)
+	m.HTTPDuration.write(bw)
+	m.DockerDuration.write(bw)
+	m.DockerErrors.write(bw)
+	writeMetricHeader(bw, "conman_log_streams_active", "Log streams currently open.", "gauge")
+	writeSample(bw, "conman_log_streams_active", nil, nil, float64(streams.Active()))
+
+	m.mu.Lock()
+	collectors := m.collectors
+	m.mu.Unlock()
+	for _, collect := range collectors {
+		collect(bw)
+	}
+	bw.Flush()
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (cv *counterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	cv.mu.Lock()
+	cv.values[key] += v
+	cv.mu.Unlock()
+}
+
+func (cv *counterVec) Inc(labelValues ...string) {
+	cv.Add(1, labelValues...)
+}
+
+func (cv *counterVec) write(w io.Writer) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	writeMetricHeader(w, cv.name, cv.help, "counter")
+	for _, key := range sortedKeys(cv.values) {
+		writeSample(w, cv.name, cv.labels, strings.Split(key, "\xff"), cv.values[key])
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (hv *histogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	h, ok := hv.series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(hv.buckets))}
+		hv.series[key] = h
+	}
+	for i, le := range hv.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (hv *histogramVec) write(w io.Writer) {
+	hv.mu.Lock()
+	defer hv.mu.Unlock()
+	writeMetricHeader(w, hv.name, hv.help, "histogram")
+	keys := make([]string, 0, len(hv.series))
+	for key := range hv.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := append(append([]string{}, hv.labels...), "le")
+	for _, key := range keys {
+		h := hv.series[key]
+		values := strings.Split(key, "\xff")
+		for i, le := range hv.buckets {
+			writeSample(w, hv.name+"_bucket", labels, append(values, formatFloat(le)), float64(h.counts[i]))
+		}
+		writeSample(w, hv.name+"_bucket", labels, append(values, "+Inf"), float64(h.count))
+		writeSample(w, hv.name+"_sum", hv.labels, values, h.sum)
+		writeSample(w, hv.name+"_count", hv.labels, values, float64(h.count))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(values[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// instrumentedTransport records latency and errors of Docker API calls.
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+func (it instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := dockerOperation(req.URL.Path)
+	start := time.Now()
+	resp, err := it.base.RoundTrip(req)
+	metrics.DockerDuration.Observe(time.Since(start).Seconds(), req.Method, op)
+	if err != nil || resp.StatusCode >= 400 {
+		metrics.DockerErrors.Inc(req.Method, op)
+	}
+	return resp, err
+}
+
+var dockerOperationActions = map[string]bool{"json": true, "create": true, "prune": true, "load": true, "search": true, "get": true}
+
+// dockerOperation turns an API path such as /v1.25/containers/abc/json into
+// a label value with bounded cardinality, containers/{id}/json.
+func dockerOperation(p string) string {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	if len(segs) > 0 && strings.HasPrefix(segs[0], "v") {
+		if _, err := strconv.ParseFloat(segs[0][1:], 64); err == nil {
+			segs = segs[1:]
+		}
+	}
+	switch {
+	case len(segs) == 0:
+		return ""
+	case len(segs) == 1:
+		return segs[0]
+	case len(segs) == 2 && dockerOperationActions[segs[1]]:
+		return segs[0] + "/" + segs[1]
+	case len(segs) == 2:
+		return segs[0] + "/{id}"
+	}
+	return segs[0] + "/{id}/" + segs[len(segs)-1]
+}
+
+// collectContainerStates writes the number of containers in each state.
+func collectContainerStates(w io.Writer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := newDockerClient()
+	if err != nil {
+		return
+	}
+	cs, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return
+	}
+	states := map[string]float64{}
+	for _, c := range cs {
+		states[c.State]++
+	}
+	writeMetricHeader(w, "conman_containers", "Containers by state.", "gauge")
+	for _, state := range sortedKeys(states) {
+		writeSample(w, "conman_containers", []string{"host", "state"}, []string{dockerConfig.Host, state}, states[state])
+	}
+}
+
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "text/plain;charset=UTF-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func Readyz(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	if _, err := cli.Ping(ctx); err != nil {
+		return &apiError{Status: http.StatusServiceUnavailable, Code: "unavailable", Message: err.Error(), Err: err}
+	}
+	w.Header().Set("Content-type", "text/plain;charset=UTF-8")
+	fmt.Fprintln(w, "ok")
+	return nil
+}
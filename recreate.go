@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+const RouteContainerRecreate = "containerRecreate"
+
+func NewRecreateContainerLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteContainerRecreate, "recreate", "POST", "id", id)
+}
+
+const (
+	defaultRecreateHealthTimeout = time.Minute
+	maxRecreateHealthTimeout     = 10 * time.Minute
+	// recreateStartGrace is how long a container without a health check
+	// must keep running to count as started.
+	recreateStartGrace = 2 * time.Second
+	// recreateOldSuffix is added to the name of the replaced container while
+	// the replacement is started.
+	recreateOldSuffix = "-conman-old"
+)
+
+// RecreateContainer pulls the image of the container and replaces the
+// container with one created from the same configuration. The old container
+// is restored when the new one fails to start or to become healthy within
+// the healthTimeout query parameter. The new container, which has a new ID,
+// is returned with 201 Created. It requires the operator role.
+func RecreateContainer(auth Authenticator, links Linker) func(containerID string, w http.ResponseWriter, r *http.Request) error {
+	return func(containerID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleOperator) {
+			return errForbidden
+		}
+		healthTimeout := defaultRecreateHealthTimeout
+		if v := r.URL.Query().Get("healthTimeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > maxRecreateHealthTimeout {
+				return newBadRequestError(fmt.Sprintf("invalid healthTimeout %q, give a duration up to %v", v, maxRecreateHealthTimeout))
+			}
+			healthTimeout = d
+		}
+		c, err := recreateContainer(r.Context(), containerID, healthTimeout)
+		if err != nil {
+			return err
+		}
+		listCache.Invalidate()
+
+		c.Links.DownloadLog = NewDownloadContainerLogLink(links, r, c.ID)
+		c.Links.Recreate = NewRecreateContainerLink(links, r, c.ID)
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(b)
+		return nil
+	}
+}
+
+func recreateContainer(ctx context.Context, containerID string, healthTimeout time.Duration) (Container, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return Container{}, err
+	}
+	old, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return Container{}, err
+	}
+	if _, ok := old.Config.Labels["com.docker.swarm.service.id"]; ok {
+		return Container{}, newBadRequestError("the container belongs to a service, update the service instead")
+	}
+	if old.HostConfig.AutoRemove {
+		return Container{}, newBadRequestError("containers removed when they exit can not be recreated")
+	}
+	ref := old.Config.Image
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil || strings.HasPrefix(strings.TrimPrefix(old.Image, "sha256:"), ref) {
+		return Container{}, newBadRequestError(fmt.Sprintf("the container was created from the image ID %s, not a tag to pull", ref))
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return Container{}, newBadRequestError(fmt.Sprintf("the container image %s is pinned to a digest, there is no newer image to pull", ref))
+	}
+	if err := pullImage(ctx, ref); err != nil {
+		return Container{}, err
+	}
+	spec := recreateSpec(ctx, old)
+
+	// The containers are renamed, stopped and started from here on, the
+	// request being cancelled must not leave them half swapped.
+	ctx = context.Background()
+	name := old.Name[1:]
+	if err := cli.ContainerRename(ctx, old.ID, name+recreateOldSuffix); err != nil {
+		return Container{}, err
+	}
+	newID := ""
+	rollback := func(cause error) error {
+		errs := []string{}
+		if newID != "" {
+			if err := cli.ContainerRemove(ctx, newID, types.ContainerRemoveOptions{Force: true}); err != nil {
+				errs = append(errs, "removing the new container failed: "+err.Error())
+			}
+		}
+		if err := cli.ContainerRename(ctx, old.ID, name); err != nil {
+			errs = append(errs, "renaming the old container back failed: "+err.Error())
+		}
+		if old.State.Running {
+			if err := cli.ContainerStart(ctx, old.ID, types.ContainerStartOptions{}); err != nil {
+				errs = append(errs, "starting the old container failed: "+err.Error())
+			}
+		}
+		msg := cause.Error() + ", the old container was restored"
+		if len(errs) > 0 {
+			msg = cause.Error() + ", restoring the old container failed: " + fmt.Sprint(errs)
+		}
+		return &apiError{Status: classifyError(cause).Status, Code: classifyError(cause).Code, Message: msg, Err: cause}
+	}
+
+	created, err := cli.ContainerCreate(ctx, spec.config, spec.hostConfig, spec.networking, name)
+	if err != nil {
+		return Container{}, rollback(err)
+	}
+	newID = created.ID
+	for n, es := range spec.endpoints {
+		if err := cli.NetworkConnect(ctx, n, newID, es); err != nil {
+			return Container{}, rollback(err)
+		}
+	}
+	if old.State.Running {
+		d := 10 * time.Second
+		if old.Config.StopTimeout != nil {
+			d = time.Duration(*old.Config.StopTimeout) * time.Second
+		}
+		if err := cli.ContainerStop(ctx, old.ID, &d); err != nil {
+			return Container{}, rollback(err)
+		}
+	}
+	if err := cli.ContainerStart(ctx, newID, types.ContainerStartOptions{}); err != nil {
+		return Container{}, rollback(err)
+	}
+	ci, err := waitStarted(ctx, newID, healthTimeout)
+	if err != nil {
+		return Container{}, rollback(err)
+	}
+	if err := cli.ContainerRemove(ctx, old.ID, types.ContainerRemoveOptions{}); err != nil {
+		return Container{}, fmt.Errorf("the container was recreated but removing the old container %s failed: %w", old.ID, err)
+	}
+	return Container{ID: ci.ID, Name: name, Image: ref, State: ci.State.Status}, nil
+}
+
+// waitStarted waits for the container to become healthy or, without a
+// health check, to keep running for a short while.
+func waitStarted(ctx context.Context, id string, healthTimeout time.Duration) (types.ContainerJSON, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	started := time.Now()
+	for {
+		time.Sleep(500 * time.Millisecond)
+		ci, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return ci, err
+		}
+		switch {
+		case !ci.State.Running || ci.State.Restarting:
+			return ci, fmt.Errorf("the new container stopped with exit code %d", ci.State.ExitCode)
+		case ci.State.Health != nil && ci.State.Health.Status == types.Unhealthy:
+			return ci, errors.New("the new container is unhealthy")
+		case ci.State.Health != nil && ci.State.Health.Status == types.Healthy:
+			return ci, nil
+		case ci.State.Health != nil:
+			if time.Since(started) > healthTimeout {
+				return ci, fmt.Errorf("the new container did not become healthy within %v", healthTimeout)
+			}
+		case time.Since(started) > recreateStartGrace:
+			return ci, nil
+		}
+	}
+}
+
+type recreateContainerSpec struct {
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networking *network.NetworkingConfig
+	// endpoints are the networks connected after creating the container.
+	endpoints map[string]*network.EndpointSettings
+}
+
+// recreateSpec returns the configuration of old for a new container. Values
+// old got from its image are left out, so the new image's take effect.
+func recreateSpec(ctx context.Context, old types.ContainerJSON) recreateContainerSpec {
+	config := *old.Config
+	if cli, err := newDockerClient(); err == nil {
+		if img, _, err := cli.ImageInspectWithRaw(ctx, old.Image); err == nil && img.Config != nil {
+			withoutImageDefaults(&config, img.Config)
+		}
+	}
+	if len(old.ID) >= 12 && config.Hostname == old.ID[:12] {
+		config.Hostname = ""
+	}
+	hostConfig := *old.HostConfig
+	hostConfig.Binds = append([]string(nil), hostConfig.Binds...)
+	// Keep the data of volumes the container got from its image.
+	for _, m := range old.Mounts {
+		if m.Type == "volume" && m.Name != "" && !mountedByConfig(old.HostConfig, m.Destination) {
+			hostConfig.Binds = append(hostConfig.Binds, m.Name+":"+m.Destination)
+		}
+	}
+
+	spec := recreateContainerSpec{config: &config, hostConfig: &hostConfig, endpoints: map[string]*network.EndpointSettings{}}
+	if old.NetworkSettings == nil {
+		return spec
+	}
+	for n, es := range old.NetworkSettings.Networks {
+		settings := &network.EndpointSettings{}
+		if container.NetworkMode(n).IsUserDefined() {
+			settings.IPAMConfig = es.IPAMConfig
+			settings.Links = es.Links
+			for _, a := range es.Aliases {
+				// Docker adds the short ID of the container.
+				if len(old.ID) < 12 || a != old.ID[:12] {
+					settings.Aliases = append(settings.Aliases, a)
+				}
+			}
+		}
+		if n == string(hostConfig.NetworkMode) || (hostConfig.NetworkMode.IsDefault() && n == "bridge") {
+			spec.networking = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{n: settings}}
+		} else if !hostConfig.NetworkMode.IsHost() && !hostConfig.NetworkMode.IsContainer() && !hostConfig.NetworkMode.IsNone() {
+			spec.endpoints[n] = settings
+		}
+	}
+	return spec
+}
+
+func mountedByConfig(hc *container.HostConfig, destination string) bool {
+	for _, m := range hc.Mounts {
+		if m.Target == destination {
+			return true
+		}
+	}
+	for _, b := range hc.Binds {
+		if bindDestination(b) == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// bindDestination returns the container path of a source:destination[:mode]
+// bind.
+func bindDestination(bind string) string {
+	parts := strings.Split(bind, ":")
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[1]
+}
+
+// withoutImageDefaults clears the values of config that are the same as in
+// the configuration of its image.
+func withoutImageDefaults(config, image *container.Config) {
+	env := []string{}
+	for _, e := range config.Env {
+		if !containsString(image.Env, e) {
+			env = append(env, e)
+		}
+	}
+	config.Env = env
+	labels := map[string]string{}
+	for k, v := range config.Labels {
+		if iv, ok := image.Labels[k]; !ok || iv != v {
+			labels[k] = v
+		}
+	}
+	config.Labels = labels
+	if reflect.DeepEqual(config.Cmd, image.Cmd) {
+		config.Cmd = nil
+	}
+	if reflect.DeepEqual(config.Entrypoint, image.Entrypoint) {
+		config.Entrypoint = nil
+	}
+	if reflect.DeepEqual(config.Healthcheck, image.Healthcheck) {
+		config.Healthcheck = nil
+	}
+	if config.WorkingDir == image.WorkingDir {
+		config.WorkingDir = ""
+	}
+	if config.User == image.User {
+		config.User = ""
+	}
+	if config.StopSignal == image.StopSignal {
+		config.StopSignal = ""
+	}
+	if len(image.ExposedPorts) > 0 {
+		ports := config.ExposedPorts
+		config.ExposedPorts = nil
+		for p, v := range ports {
+			if _, ok := image.ExposedPorts[p]; !ok {
+				if config.ExposedPorts == nil {
+					config.ExposedPorts = nat.PortSet{}
+				}
+				config.ExposedPorts[p] = v
+			}
+		}
+	}
+	if len(image.Volumes) > 0 {
+		volumes := config.Volumes
+		config.Volumes = nil
+		for v := range volumes {
+			if _, ok := image.Volumes[v]; !ok {
+				if config.Volumes == nil {
+					config.Volumes = map[string]struct{}{}
+				}
+				config.Volumes[v] = struct{}{}
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+)
+
+// registries is set when a registry credentials file is configured.
+var registries *RegistryStore
+
+const (
+	RouteRegistryList   = "registryList"
+	RouteRegistryRemove = "registryRemove"
+
+	// dockerHubServer is the server name credentials for Docker Hub are
+	// stored under.
+	dockerHubServer = "docker.io"
+)
+
+var errRegistriesDisabled = &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "registry credentials are not configured"}
+
+type registryCredential struct {
+	Server   string    `json:"server"`
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Updated  time.Time `json:"updated"`
+}
+
+// RegistryStore keeps registry credentials in a file encrypted with
+// AES-256-GCM.
+type RegistryStore struct {
+	file string
+	aead cipher.AEAD
+
+	mu    sync.RWMutex
+	creds map[string]registryCredential
+}
+
+// NewRegistryStore reads the credentials in file, if it exists, with the
+// base64 encoded 32 byte key.
+func NewRegistryStore(file, key string) (*RegistryStore, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, errors.New("the registry credentials key must be 32 bytes, base64 encoded")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	rs := &RegistryStore{file: file, aead: aead, creds: map[string]registryCredential{}}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("registry credentials file %s is truncated", file)
+	}
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting registry credentials file %s, is the key right? %w", file, err)
+	}
+	creds := []registryCredential{}
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return nil, err
+	}
+	for _, c := range creds {
+		rs.creds[c.Server] = c
+	}
+	return rs, nil
+}
+
+// save writes the credentials to a temporary file which replaces the file,
+// so a failed write does not lose them. The caller holds the lock.
+func (rs *RegistryStore) save() error {
+	creds := []registryCredential{}
+	for _, c := range rs.creds {
+		creds = append(creds, c)
+	}
+	plain, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, rs.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(rs.file), filepath.Base(rs.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(rs.aead.Seal(nonce, nonce, plain, nil)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), rs.file)
+}
+
+func (rs *RegistryStore) put(c registryCredential) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	old, existed := rs.creds[c.Server]
+	rs.creds[c.Server] = c
+	if err := rs.save(); err != nil {
+		if existed {
+			rs.creds[c.Server] = old
+		} else {
+			delete(rs.creds, c.Server)
+		}
+		return err
+	}
+	return nil
+}
+
+func (rs *RegistryStore) remove(server string) (bool, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	old, ok := rs.creds[server]
+	if !ok {
+		return false, nil
+	}
+	delete(rs.creds, server)
+	if err := rs.save(); err != nil {
+		rs.creds[server] = old
+		return true, err
+	}
+	return true, nil
+}
+
+func (rs *RegistryStore) list() []registryCredential {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	creds := []registryCredential{}
+	for _, c := range rs.creds {
+		creds = append(creds, c)
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Server < creds[j].Server })
+	return creds
+}
+
+// lookup returns the credentials for the registry of an image, as given by
+// reference.Domain.
+func (rs *RegistryStore) lookup(server string) (registryCredential, bool) {
+	if rs == nil {
+		return registryCredential{}, false
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	c, ok := rs.creds[normalizeRegistryServer(server)]
+	return c, ok
+}
+
+// encodedAuth returns the X-Registry-Auth value for pulling ref, empty when
+// there are no credentials for its registry.
+func (rs *RegistryStore) encodedAuth(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", nil
+	}
+	c, ok := rs.lookup(reference.Domain(named))
+	if !ok {
+		return "", nil
+	}
+	server := c.Server
+	if server == dockerHubServer {
+		server = "https://index.docker.io/v1/"
+	}
+	b, err := json.Marshal(types.AuthConfig{Username: c.Username, Password: c.Password, ServerAddress: server})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// normalizeRegistryServer maps the names Docker Hub goes by to one and drops
+// schemes and paths from server addresses.
+func normalizeRegistryServer(server string) string {
+	server = strings.ToLower(strings.TrimSpace(server))
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if i := strings.IndexByte(server, '/'); i >= 0 {
+		server = server[:i]
+	}
+	switch server {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubServer
+	}
+	return server
+}
+
+// Registry is a stored credential as listed, without the password.
+type Registry struct {
+	Server   string        `json:"server"`
+	Username string        `json:"username"`
+	Updated  time.Time     `json:"updated"`
+	Links    RegistryLinks `json:"links"`
+}
+
+type RegistryLinks struct {
+	Remove *hateoasLink `json:"remove,omitempty"`
+}
+
+type RegistryCredentialRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func ListRegistries(auth Authenticator, links Linker) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		if registries == nil {
+			return errRegistriesDisabled
+		}
+		list := []Registry{}
+		for _, c := range registries.list() {
+			list = append(list, Registry{
+				Server:   c.Server,
+				Username: c.Username,
+				Updated:  c.Updated,
+				Links:    RegistryLinks{Remove: links.Link(r, RouteRegistryRemove, "remove", "DELETE", "id", c.Server)},
+			})
+		}
+		b, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
+
+// PutRegistry stores the credentials for the registry in the path, replacing
+// any stored before.
+func PutRegistry(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		if registries == nil {
+			return errRegistriesDisabled
+		}
+		server := normalizeRegistryServer(mux.Vars(r)["id"])
+		if server == "" {
+			return newBadRequestError("registry server is required")
+		}
+		var req RegistryCredentialRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return newBadRequestError(fmt.Sprintf("invalid credentials: %v", err))
+		}
+		if req.Username == "" || req.Password == "" {
+			return newBadRequestError("username and password are required")
+		}
+		if err := registries.put(registryCredential{Server: server, Username: req.Username, Password: req.Password, Updated: time.Now().UTC()}); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+func RemoveRegistry(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		if registries == nil {
+			return errRegistriesDisabled
+		}
+		ok, err := registries.remove(normalizeRegistryServer(mux.Vars(r)["id"]))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "no credentials for the registry"}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
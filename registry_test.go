@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func testRegistryKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestRegistryStoreRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registries")
+	key := testRegistryKey()
+	rs, err := NewRegistryStore(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := registryCredential{Server: "registry.example.com", Username: "ci-deploy", Password: "s3cret", Updated: time.Now().UTC().Truncate(time.Second)}
+	if err := rs.put(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.put(registryCredential{Server: dockerHubServer, Username: "hub", Password: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"s3cret", "hunter2", "registry.example.com", "ci-deploy"} {
+		if bytes.Contains(raw, []byte(s)) {
+			t.Errorf("%q stored in plaintext", s)
+		}
+	}
+
+	rs, err = NewRegistryStore(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := rs.lookup("https://Registry.example.com/v2/")
+	if !ok || !got.Updated.Equal(want.Updated) {
+		t.Errorf("got %+v %v, want %+v", got, ok, want)
+	}
+	got.Updated = want.Updated
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if _, ok := rs.lookup("index.docker.io"); !ok {
+		t.Error("Docker Hub credentials not found")
+	}
+
+	if removed, err := rs.remove(dockerHubServer); err != nil || !removed {
+		t.Fatalf("remove: %v %v", removed, err)
+	}
+	rs, err = NewRegistryStore(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds := rs.list(); len(creds) != 1 || creds[0].Server != "registry.example.com" {
+		t.Errorf("after remove %+v", creds)
+	}
+}
+
+func TestRegistryStoreWrongKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "registries")
+	rs, err := NewRegistryStore(file, testRegistryKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.put(registryCredential{Server: "registry.example.com", Username: "ci", Password: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistryStore(file, testRegistryKey()); err == nil {
+		t.Error("opened with the wrong key")
+	}
+	if _, err := NewRegistryStore(file, base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("opened with a short key")
+	}
+	if err := ioutil.WriteFile(file, []byte("abc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRegistryStore(file, testRegistryKey()); err == nil {
+		t.Error("opened a truncated file")
+	}
+}
+
+func TestListRegistriesHidesPasswords(t *testing.T) {
+	useRegistries(t, registryCredential{Server: "registry.example.com", Username: "ci", Password: "s3cret"})
+	router := mux.NewRouter()
+	router.HandleFunc("/registries/{id}", func(http.ResponseWriter, *http.Request) {}).Name(RouteRegistryRemove)
+	auth := HTTPHeaderAuthenticator{HTTPHeader: "X-User", Roles: map[string][]string{RoleAdmin: {"root"}}}
+	list := ListRegistries(auth, Linker{Router: router})
+
+	r := httptest.NewRequest("GET", "/registries", nil)
+	r.Header.Set("X-User", "alice")
+	if err := list(httptest.NewRecorder(), r); err != errForbidden {
+		t.Errorf("listed for a non-admin: %v", err)
+	}
+
+	r.Header.Set("X-User", "root")
+	w := httptest.NewRecorder()
+	if err := list(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(w.Body.String(), "s3cret") || strings.Contains(strings.ToLower(w.Body.String()), "password") {
+		t.Errorf("password listed: %s", w.Body)
+	}
+	var got []Registry
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Server != "registry.example.com" || got[0].Username != "ci" || got[0].Links.Remove == nil {
+		t.Errorf("listed %+v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// streams tracks responses that stay open for as long as the client reads,
+// such as log downloads, so they can be ended when the server shuts down.
+var streams = newStreamTracker()
+
+type streamTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	active int64
+}
+
+func newStreamTracker() *streamTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamTracker{ctx: ctx, cancel: cancel}
+}
+
+// Context returns a context that ends when the request does or when the
+// tracker is closed. The returned function must be called when the stream
+// ends.
+func (st *streamTracker) Context(r *http.Request) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	atomic.AddInt64(&st.active, 1)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-st.ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+	return ctx, func() {
+		close(stop)
+		cancel()
+		atomic.AddInt64(&st.active, -1)
+	}
+}
+
+func (st *streamTracker) Active() int64 {
+	return atomic.LoadInt64(&st.active)
+}
+
+// Close ends all current and future streams.
+func (st *streamTracker) Close() {
+	st.cancel()
+}
+
+// limitConcurrency rejects requests with 503 Service Unavailable while max
+// requests are already being served.
+func limitConcurrency(max int, next http.Handler) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	sem := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			writeError(w, r, &apiError{Status: http.StatusServiceUnavailable, Code: "busy", Message: "too many concurrent requests"})
+		}
+	})
+}
+
+// withTimeout bounds the time to produce a response. It buffers the response
+// so it must not be used for streaming routes. The request context is done
+// at the timeout, handlers pass it to Docker so their calls stop as well.
+func withTimeout(timeout time.Duration, h http.HandlerFunc) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	th := http.TimeoutHandler(h, timeout, `{"code":"timeout","message":"request timed out"}`)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		th.ServeHTTP(timeoutResponseWriter{w}, r)
+	})
+}
+
+// timeoutResponseWriter labels the body http.TimeoutHandler writes on a
+// timeout as JSON.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutResponseWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.Header().Get("Content-type") == "" {
+		w.Header().Set("Content-type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
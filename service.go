@@ -1,19 +1,24 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"time"
+	"strings"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
 )
 
+// RoleOperator may redeploy the services it manages, and recreate the
+// containers it manages and send signals to their processes.
+const RoleOperator = "operator"
+
 type ServiceLinks struct {
 	DownloadLog *hateoasLink `json:"downloadLog,omitempty"`
+	Update      *hateoasLink `json:"update,omitempty"`
 }
 
 type Service struct {
@@ -23,25 +28,64 @@ type Service struct {
 	Links ServiceLinks `json:"links"`
 }
 
-func NewDownloadServiceLogLink(id string) *hateoasLink {
-	return &hateoasLink{Href: fmt.Sprintf("/api/services/%s/log/download", id), Rel: "downloadLog", Type: "GET"}
+const (
+	RouteServiceList        = "serviceList"
+	RouteServiceLogDownload = "serviceLogDownload"
+	RouteServiceUpdate      = "serviceUpdate"
+)
+
+func NewDownloadServiceLogLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteServiceLogDownload, "downloadLog", "GET", "id", id)
 }
 
-func ListServices(auth Authenticator) func(w http.ResponseWriter, r *http.Request) error {
+func NewUpdateServiceLink(links Linker, r *http.Request, id string) *hateoasLink {
+	return links.Link(r, RouteServiceUpdate, "update", "POST", "id", id)
+}
+
+var serviceSortKeys = []string{"name", "image", "created"}
+
+func serviceSortKey(svc swarm.Service, key string) string {
+	switch key {
+	case "name":
+		return svc.Spec.Name
+	case "image":
+		return svc.Spec.TaskTemplate.ContainerSpec.Image
+	}
+	return timeSortKey(svc.CreatedAt)
+}
+
+func ListServices(auth Authenticator, links Linker) func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		cli, err := client.NewEnvClient()
+		opts, err := parseListOptions(r, serviceSortKeys, "name")
+		if err != nil {
+			return err
+		}
+		cli, err := newDockerClient()
 		if err != nil {
 			return err
 		}
 
-		serviceList, err := cli.ServiceList(context.Background(), types.ServiceListOptions{})
+		q := r.URL.Query()
+		f := filters.NewArgs()
+		for _, v := range q["name"] {
+			f.Add("name", v)
+		}
+		for _, v := range q["label"] {
+			f.Add("label", v)
+		}
+		serviceList, err := cli.ServiceList(r.Context(), types.ServiceListOptions{Filters: f})
 		if err != nil {
 			return err
 		}
 
-		services := []Service{}
+		// The service list API has no image filter.
+		image := q.Get("image")
+		byID := map[string]swarm.Service{}
+		items := []listItem{}
 		for _, svc := range serviceList {
-			service := Service{}
+			if image != "" && !strings.Contains(svc.Spec.TaskTemplate.ContainerSpec.Image, image) {
+				continue
+			}
 			allowed, err := auth.IsServiceAllowed(r, svc.ID)
 			if err != nil {
 				return err
@@ -49,17 +93,29 @@ func ListServices(auth Authenticator) func(w http.ResponseWriter, r *http.Reques
 			if !allowed {
 				continue
 			}
+			byID[svc.ID] = svc
+			items = append(items, listItem{ID: svc.ID, Key: serviceSortKey(svc, opts.sortKey)})
+		}
+		page, next := opts.page(items)
+
+		services := []Service{}
+		for _, item := range page {
+			svc := byID[item.ID]
+			service := Service{}
 			service.ID = svc.ID
 			service.Name = svc.Spec.Name
 			service.Image = svc.Spec.TaskTemplate.ContainerSpec.Image
-			service.Links.DownloadLog = NewDownloadServiceLogLink(svc.ID)
+			service.Links.DownloadLog = NewDownloadServiceLogLink(links, r, svc.ID)
+			if auth.HasRole(r, RoleOperator) {
+				service.Links.Update = NewUpdateServiceLink(links, r, svc.ID)
+			}
 			services = append(services, service)
 		}
 		b, err := json.Marshal(services)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return err
 		}
+		setNextLink(w, r, links, RouteServiceList, next)
 		w.Header().Set("Content-type", "application/json")
 		fmt.Fprint(w, string(b))
 		return nil
@@ -67,27 +123,60 @@ func ListServices(auth Authenticator) func(w http.ResponseWriter, r *http.Reques
 }
 
 func DownloadServiceLog(serviceID string, w http.ResponseWriter, r *http.Request) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	ctx, done := streams.Context(r)
+	defer done()
 
-	client, _ := client.NewEnvClient()
-	cjson, err := client.ContainerInspect(context.Background(), serviceID)
+	client, _ := newDockerClient()
+	svc, _, err := client.ServiceInspectWithRaw(ctx, serviceID)
 	if err != nil {
 		return err
 	}
+	return downloadLog(ctx, logTarget{Type: logTargetService, ID: svc.ID, Name: svc.Spec.Name}, w, r)
+}
 
-	w.Header().Set("Content-type", "text/plain;charset=UTF-8")
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+cjson.Name+"\"")
-
-	creader, err := client.ContainerLogs(ctx, serviceID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: false, Details: false, Timestamps: false})
-	if err != nil {
-		return err
-	}
+// UpdateService redeploys the service with the image its tag currently
+// points at, passing the stored registry credentials on to the nodes.
+func UpdateService(auth Authenticator) func(serviceID string, w http.ResponseWriter, r *http.Request) error {
+	return func(serviceID string, w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleOperator) {
+			return errForbidden
+		}
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		svc, _, err := cli.ServiceInspectWithRaw(r.Context(), serviceID)
+		if err != nil {
+			return err
+		}
+		spec := svc.Spec
+		image := spec.TaskTemplate.ContainerSpec.Image
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			return newBadRequestError(fmt.Sprintf("the service image %s is not a reference to update", image))
+		}
+		// docker service create pins the image to the digest of the tag, the
+		// digest is dropped for the nodes to resolve the tag again.
+		if tagged, ok := named.(reference.Tagged); ok {
+			named, err = reference.WithTag(reference.TrimNamed(named), tagged.Tag())
+			if err != nil {
+				return err
+			}
+		} else if _, ok := named.(reference.Canonical); ok {
+			return newBadRequestError(fmt.Sprintf("the service image %s is pinned to a digest without a tag", image))
+		}
+		spec.TaskTemplate.ContainerSpec.Image = reference.FamiliarString(named)
+		spec.TaskTemplate.ForceUpdate++
 
-	_, err = io.Copy(w, creader)
-	if err != nil && err != io.EOF {
-		return err
+		registryAuth, err := registries.encodedAuth(spec.TaskTemplate.ContainerSpec.Image)
+		if err != nil {
+			return err
+		}
+		if _, err := cli.ServiceUpdate(r.Context(), svc.ID, svc.Version, spec, types.ServiceUpdateOptions{EncodedRegistryAuth: registryAuth}); err != nil {
+			return err
+		}
+		listCache.Invalidate()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
 	}
-
-	return nil
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
+)
+
+const RouteTemplateInstantiate = "templateInstantiate"
+
+var templatePlaceholder = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+type TemplateParameter struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+	Default     string `json:"default,omitempty" yaml:"default"`
+	Required    bool   `json:"required" yaml:"required"`
+	// Pattern is a regular expression the whole value must match.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern"`
+}
+
+// Template is a named container setup read from a YAML file. Strings in the
+// container may hold ${parameter} placeholders.
+type Template struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description"`
+	// Roles may instantiate the template, anyone allowed to create
+	// containers when empty.
+	Roles      []string               `json:"roles,omitempty" yaml:"roles"`
+	Parameters []TemplateParameter    `json:"parameters" yaml:"parameters"`
+	Container  ContainerCreateRequest `json:"-" yaml:"container"`
+	Links      TemplateLinks          `json:"links" yaml:"-"`
+}
+
+type TemplateLinks struct {
+	Instantiate *hateoasLink `json:"instantiate,omitempty"`
+}
+
+func (t Template) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("template has no name")
+	}
+	params := map[string]bool{}
+	for _, p := range t.Parameters {
+		if p.Name == "" || params[p.Name] {
+			return fmt.Errorf("template %s: parameter names must be unique and not empty", t.Name)
+		}
+		params[p.Name] = true
+		if p.Pattern != "" {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return fmt.Errorf("template %s: parameter %s: %w", t.Name, p.Name, err)
+			}
+		}
+	}
+	var err error
+	t.Container.substitute(func(s string) string {
+		for _, m := range templatePlaceholder.FindAllStringSubmatch(s, -1) {
+			if !params[m[1]] && err == nil {
+				err = fmt.Errorf("template %s: unknown parameter %s", t.Name, m[1])
+			}
+		}
+		return s
+	})
+	return err
+}
+
+func (t Template) allowed(auth Authenticator, r *http.Request) bool {
+	if len(t.Roles) == 0 {
+		return auth.Subject(r) != "" || auth.HasRole(r, RoleAdmin)
+	}
+	for _, role := range t.Roles {
+		if auth.HasRole(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// instantiate returns the container of the template with the placeholders
+// replaced by the given values, or the parameter defaults.
+func (t Template) instantiate(values map[string]string) (ContainerCreateRequest, error) {
+	resolved := map[string]string{}
+	for _, p := range t.Parameters {
+		v, ok := values[p.Name]
+		if !ok || v == "" {
+			v = p.Default
+		}
+		if v == "" && p.Required {
+			return ContainerCreateRequest{}, newBadRequestError(fmt.Sprintf("parameter %s is required", p.Name))
+		}
+		if p.Pattern != "" && !regexp.MustCompile("^(?:"+p.Pattern+")$").MatchString(v) {
+			return ContainerCreateRequest{}, newBadRequestError(fmt.Sprintf("parameter %s does not match %s", p.Name, p.Pattern))
+		}
+		resolved[p.Name] = v
+	}
+	for name := range values {
+		if _, ok := resolved[name]; !ok {
+			return ContainerCreateRequest{}, newBadRequestError(fmt.Sprintf("template %s has no parameter %s", t.Name, name))
+		}
+	}
+	req := t.Container.copy()
+	req.substitute(func(s string) string {
+		return templatePlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+			return resolved[m[2:len(m)-1]]
+		})
+	})
+	return req, nil
+}
+
+func (req ContainerCreateRequest) copy() ContainerCreateRequest {
+	c := req
+	c.Cmd = append([]string(nil), req.Cmd...)
+	c.Env = append([]string(nil), req.Env...)
+	c.Ports = append([]string(nil), req.Ports...)
+	c.Networks = append([]string(nil), req.Networks...)
+	c.Mounts = append([]ContainerMountRequest(nil), req.Mounts...)
+	c.Labels = map[string]string{}
+	for k, v := range req.Labels {
+		c.Labels[k] = v
+	}
+	return c
+}
+
+// substitute replaces every string of the request with f applied to it.
+func (req *ContainerCreateRequest) substitute(f func(string) string) {
+	all := func(list []string) {
+		for i := range list {
+			list[i] = f(list[i])
+		}
+	}
+	req.Name = f(req.Name)
+	req.Image = f(req.Image)
+	req.RestartPolicy = f(req.RestartPolicy)
+	all(req.Cmd)
+	all(req.Env)
+	all(req.Ports)
+	all(req.Networks)
+	for i := range req.Mounts {
+		req.Mounts[i].Source = f(req.Mounts[i].Source)
+		req.Mounts[i].Target = f(req.Mounts[i].Target)
+	}
+	for k, v := range req.Labels {
+		req.Labels[k] = f(v)
+	}
+}
+
+// TemplateStore reads the templates from the .yaml and .yml files in Dir on
+// every request, so they can be edited without restarting.
+type TemplateStore struct {
+	Dir    string
+	ErrLog *log.Logger
+}
+
+// Load returns the valid templates by name, invalid files are logged and
+// skipped.
+func (ts TemplateStore) Load() (map[string]Template, error) {
+	templates := map[string]Template{}
+	if ts.Dir == "" {
+		return templates, nil
+	}
+	entries, err := ioutil.ReadDir(ts.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return templates, nil
+		}
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		file := filepath.Join(ts.Dir, e.Name())
+		t, err := readTemplate(file)
+		if err == nil {
+			if _, dup := templates[t.Name]; dup {
+				err = fmt.Errorf("template %s is defined more than once", t.Name)
+			}
+		}
+		if err != nil {
+			ts.ErrLog.Printf("template file %s: %v", file, err)
+			continue
+		}
+		templates[t.Name] = t
+	}
+	return templates, nil
+}
+
+func readTemplate(file string) (Template, error) {
+	var t Template
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return t, err
+	}
+	if err := yaml.UnmarshalStrict(b, &t); err != nil {
+		return t, err
+	}
+	return t, t.validate()
+}
+
+func ListTemplates(auth Authenticator, ts TemplateStore, links Linker) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		templates, err := ts.Load()
+		if err != nil {
+			return err
+		}
+		list := []Template{}
+		for _, t := range templates {
+			if !t.allowed(auth, r) {
+				continue
+			}
+			t.Links.Instantiate = links.Link(r, RouteTemplateInstantiate, "instantiate", "POST", "name", t.Name)
+			list = append(list, t)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		b, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
+
+// TemplateInstantiateRequest holds the parameter values by name.
+type TemplateInstantiateRequest struct {
+	Parameters map[string]string `json:"parameters"`
+}
+
+// InstantiateTemplate creates and starts a container from a template. The
+// container is subject to the same policy as any other container created
+// through conman.
+func InstantiateTemplate(auth Authenticator, ts TemplateStore, policy ContainerCreateConfig, authLabel string, links Linker) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		templates, err := ts.Load()
+		if err != nil {
+			return err
+		}
+		t, ok := templates[mux.Vars(r)["name"]]
+		if !ok {
+			return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "no such template"}
+		}
+		if !t.allowed(auth, r) {
+			return errForbidden
+		}
+		var tr TemplateInstantiateRequest
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&tr); err != nil {
+			return newBadRequestError(fmt.Sprintf("invalid parameters: %v", err))
+		}
+		req, err := t.instantiate(tr.Parameters)
+		if err != nil {
+			return err
+		}
+		return respondCreated(w, r, auth, policy, authLabel, links, req)
+	}
+}
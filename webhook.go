@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const webhookDeliveryLogSize = 500
+
+type WebhookConfig struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url" yaml:"url"`
+	// Secret signs the payload, the signature is sent as
+	// X-Conman-Signature: sha256=<hex encoded HMAC-SHA256 of the body>.
+	Secret string `json:"secret" yaml:"secret"`
+	// Actions limits the webhook to these actions, such as
+	// container.remove, all actions when empty.
+	Actions     []string `json:"actions" yaml:"actions"`
+	MaxAttempts int      `json:"maxAttempts" yaml:"maxAttempts"`
+}
+
+func validateWebhooks(hooks []WebhookConfig) error {
+	names := map[string]bool{}
+	for _, h := range hooks {
+		if h.Name == "" || names[h.Name] {
+			return fmt.Errorf("webhook names must be unique and not empty, got %q", h.Name)
+		}
+		names[h.Name] = true
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook %s has an invalid URL %q", h.Name, h.URL)
+		}
+		if h.MaxAttempts < 0 {
+			return fmt.Errorf("webhook %s: max attempts can not be negative", h.Name)
+		}
+	}
+	return nil
+}
+
+// WebhookEvent is the payload posted to webhooks.
+type WebhookEvent struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Identity   string    `json:"identity,omitempty"`
+	TargetType string    `json:"targetType"`
+	TargetID   string    `json:"targetId"`
+	TargetName string    `json:"targetName,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+}
+
+// WebhookDelivery is one attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	Time     time.Time `json:"time"`
+	Webhook  string    `json:"webhook"`
+	EventID  string    `json:"eventId"`
+	Action   string    `json:"action"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration float64   `json:"durationSeconds"`
+	Success  bool      `json:"success"`
+}
+
+// WebhookDispatcher is an audit sink posting successful actions to the
+// configured webhooks. Failed deliveries are retried with exponential
+// backoff, every attempt is kept in a delivery log.
+type WebhookDispatcher struct {
+	hooks  []WebhookConfig
+	client *http.Client
+	errLog *log.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu         sync.Mutex
+	deliveries []WebhookDelivery
+}
+
+func NewWebhookDispatcher(hooks []WebhookConfig, errLog *log.Logger) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		hooks:  hooks,
+		client: &http.Client{Timeout: 10 * time.Second},
+		errLog: errLog,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (wd *WebhookDispatcher) Write(rec AuditRecord) error {
+	if rec.Outcome != AuditOutcomeSuccess {
+		return nil
+	}
+	event := WebhookEvent{
+		ID:         newRequestID(),
+		Time:       rec.Time,
+		Action:     rec.Action,
+		Identity:   rec.Identity,
+		TargetType: rec.TargetType,
+		TargetID:   rec.TargetID,
+		TargetName: rec.TargetName,
+		RequestID:  rec.RequestID,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, h := range wd.hooks {
+		if !webhookWantsAction(h, event.Action) {
+			continue
+		}
+		wd.wg.Add(1)
+		go wd.deliver(h, event, body)
+	}
+	return nil
+}
+
+func webhookWantsAction(h WebhookConfig, action string) bool {
+	if len(h.Actions) == 0 {
+		return true
+	}
+	for _, a := range h.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (wd *WebhookDispatcher) deliver(h WebhookConfig, event WebhookEvent, body []byte) {
+	defer wd.wg.Done()
+	attempts := h.MaxAttempts
+	if attempts == 0 {
+		attempts = 5
+	}
+	backoff := time.Second
+	for attempt := 1; attempt <= attempts; attempt++ {
+		d := wd.post(h, event, body)
+		d.Attempt = attempt
+		wd.record(d)
+		if d.Success {
+			return
+		}
+		if attempt == attempts {
+			wd.errLog.Printf("webhook %s: giving up on event %s after %d attempts: %s", h.Name, event.ID, attempts, d.Error)
+			return
+		}
+		select {
+		case <-wd.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (wd *WebhookDispatcher) post(h WebhookConfig, event WebhookEvent, body []byte) (d WebhookDelivery) {
+	d = WebhookDelivery{Time: time.Now(), Webhook: h.Name, EventID: event.ID, Action: event.Action}
+	defer func() { d.Duration = time.Since(d.Time).Seconds() }()
+
+	req, err := http.NewRequestWithContext(wd.ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	req.Header.Set("Content-type", "application/json")
+	req.Header.Set("User-Agent", "conman-webhook")
+	req.Header.Set("X-Conman-Event", event.Action)
+	req.Header.Set("X-Conman-Delivery", event.ID)
+	if h.Secret != "" {
+		req.Header.Set("X-Conman-Signature", "sha256="+signPayload(h.Secret, body))
+	}
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	d.Status = resp.StatusCode
+	d.Success = resp.StatusCode < 300
+	if !d.Success {
+		d.Error = "unexpected status " + resp.Status
+	}
+	return d
+}
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wd *WebhookDispatcher) record(d WebhookDelivery) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if len(wd.deliveries) == webhookDeliveryLogSize {
+		copy(wd.deliveries, wd.deliveries[1:])
+		wd.deliveries = wd.deliveries[:webhookDeliveryLogSize-1]
+	}
+	wd.deliveries = append(wd.deliveries, d)
+}
+
+// Deliveries returns up to limit delivery attempts, newest first.
+func (wd *WebhookDispatcher) Deliveries(limit int) []WebhookDelivery {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if limit <= 0 || limit > len(wd.deliveries) {
+		limit = len(wd.deliveries)
+	}
+	deliveries := make([]WebhookDelivery, 0, limit)
+	for i := len(wd.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		deliveries = append(deliveries, wd.deliveries[i])
+	}
+	return deliveries
+}
+
+// Close abandons pending retries and waits for the deliveries to end.
+func (wd *WebhookDispatcher) Close() error {
+	wd.cancel()
+	wd.wg.Wait()
+	return nil
+}
+
+func ListWebhookDeliveries(auth Authenticator, wd *WebhookDispatcher) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !auth.HasRole(r, RoleAdmin) {
+			return errForbidden
+		}
+		limit, err := queryLimit(r, 100)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(wd.Deliveries(limit))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-type", "application/json")
+		fmt.Fprint(w, string(b))
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+//go:embed www
+var embeddedWWW embed.FS
+
+// assetRefPattern matches references to versioned assets in index.html.
+var assetRefPattern = regexp.MustCompile(`(src|href)="((?:script|css)/[^"?]+)"`)
+
+// staticHandler serves the web UI. Files under script/ and css/ are versioned
+// by a hash of their content which index.html references them with, so they
+// can be cached for good while index.html itself is always revalidated.
+type staticHandler struct {
+	files   fs.FS
+	urlRoot string
+	// dev serves from disk, hashes are then computed on every request so
+	// edits show up on reload.
+	dev bool
+
+	hashes map[string]string
+	index  []byte
+}
+
+func NewStaticHandler(dir, urlRoot string) (http.Handler, error) {
+	sh := &staticHandler{urlRoot: urlRoot}
+	if dir != "" {
+		sh.files = os.DirFS(dir)
+		sh.dev = true
+		return sh, nil
+	}
+	files, err := fs.Sub(embeddedWWW, "www")
+	if err != nil {
+		return nil, err
+	}
+	sh.files = files
+	if sh.hashes, err = hashAssets(files); err != nil {
+		return nil, err
+	}
+	if sh.index, err = renderIndex(files, urlRoot, sh.hashes); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+func hashAssets(files fs.FS) (map[string]string, error) {
+	hashes := map[string]string{}
+	for _, dir := range []string{"script", "css"} {
+		err := fs.WalkDir(files, dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			b, err := fs.ReadFile(files, p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(b)
+			hashes[p] = hex.EncodeToString(sum[:6])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// renderIndex sets the base URL to the URL root and adds the content hash to
+// asset references.
+func renderIndex(files fs.FS, urlRoot string, hashes map[string]string) ([]byte, error) {
+	b, err := fs.ReadFile(files, "index.html")
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.Replace(b, []byte("<head>"), []byte(`<head>
+    <base href="`+html.EscapeString(urlRoot)+`/">`), 1)
+	return assetRefPattern.ReplaceAllFunc(b, func(ref []byte) []byte {
+		m := assetRefPattern.FindSubmatch(ref)
+		h, ok := hashes[string(m[2])]
+		if !ok {
+			return ref
+		}
+		return []byte(string(m[1]) + `="` + string(m[2]) + "?v=" + h + `"`)
+	}), nil
+}
+
+func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hashes, index := sh.hashes, sh.index
+	if sh.dev {
+		var err error
+		if hashes, err = hashAssets(sh.files); err == nil {
+			index, err = renderIndex(sh.files, sh.urlRoot, hashes)
+		}
+		if err != nil {
+			writeError(w, r, classifyError(err))
+			return
+		}
+	}
+
+	p := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if p == "" || p == "index.html" {
+		w.Header().Set("Content-type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(index)
+		return
+	}
+	if h, ok := hashes[p]; ok {
+		w.Header().Set("ETag", `"`+h+`"`)
+		if r.URL.Query().Get("v") == h {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	}
+	http.FileServer(http.FS(sh.files)).ServeHTTP(w, r)
+}
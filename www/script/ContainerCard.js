@@ -1,8 +1,11 @@
 export var ContainerCard = {
-    props: ['container'],
+    props: ['container', 'selected'],
     template: `
 <div class="card-body">
     <div class="row align-items-center">
+        <div class="col-auto">
+            <input type="checkbox" :checked="selected" @change="$emit('select', $event.target.checked)" title="Select to follow logs">
+        </div>
         <div class="col">
             <div class="row">
                 <div class="col text-muted">Name</div>
@@ -12,7 +15,10 @@ export var ContainerCard = {
             </div>
             <div class="row">
                 <div class="col">{{ container.name }}</div>
-                <div class="col">{{ container.image }}</div>
+                <div class="col">
+                    {{ container.image }}
+                    <a v-if="container.updateAvailable && container.links.recreate" href="#" class="badge badge-info" @click="$emit('action', container.links.recreate)" title="A newer image is available, click to recreate the container with it">update</a>
+                </div>
                 <div class="col">
                     <div class="badge" :class="stateClass(container.state)">
                         {{ container.state }}
@@ -33,6 +39,34 @@ export var ContainerCard = {
                             </svg>
                             Download log
                         </a>
+                        <a class="dropdown-item" @click="$emit('action', container.links.recreate)" :class="container.links.recreate ? '' : 'disabled'" href="#" title="Pull the image and recreate the container">
+                            <svg width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-arrow-repeat mb-1 mr-2" fill="currentColor" xmlns="http://www.w3.org/2000/svg">
+                                <path d="M11.534 7h3.932a.25.25 0 0 1 .192.41l-1.966 2.36a.25.25 0 0 1-.384 0l-1.966-2.36a.25.25 0 0 1 .192-.41zm-11 2h3.932a.25.25 0 0 0 .192-.41L2.692 6.23a.25.25 0 0 0-.384 0L.342 8.59A.25.25 0 0 0 .534 9z"/>
+                                <path fill-rule="evenodd" d="M8 3c-1.552 0-2.94.707-3.857 1.818a.5.5 0 1 1-.771-.636A6.002 6.002 0 0 1 13.917 7H12.9A5.002 5.002 0 0 0 8 3zM3.1 9a5.002 5.002 0 0 0 8.757 2.182.5.5 0 1 1 .771.636A6.002 6.002 0 0 1 2.083 9H3.1z"/>
+                            </svg>
+                            Recreate with latest image
+                        </a>
+                        <a v-if="container.links.files" class="dropdown-item" @click="$emit('files')" href="#">
+                            <svg width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-folder mb-1 mr-2" fill="currentColor" xmlns="http://www.w3.org/2000/svg">
+                                <path d="M.54 3.87L.5 3a2 2 0 0 1 2-2h3.672a2 2 0 0 1 1.414.586l.828.828A2 2 0 0 0 9.828 3h3.982a2 2 0 0 1 1.992 2.181l-.637 7A2 2 0 0 1 13.174 14H2.826a2 2 0 0 1-1.991-1.819l-.637-7a1.99 1.99 0 0 1 .342-1.31zM2.19 4a1 1 0 0 0-.996 1.09l.637 7a1 1 0 0 0 .995.91h10.348a1 1 0 0 0 .995-.91l.637-7A1 1 0 0 0 13.81 4H2.19zm4.69-1.707A1 1 0 0 0 6.172 2H2.5a1 1 0 0 0-1 .981l.006.139C1.72 3.042 1.95 3 2.19 3h5.396l-.707-.707z"/>
+                            </svg>
+                            Browse files
+                        </a>
+                        <a class="dropdown-item" @click="$emit('diff')" :class="container.links.diff ? '' : 'disabled'" href="#">
+                            <svg width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-file-diff mb-1 mr-2" fill="currentColor" xmlns="http://www.w3.org/2000/svg">
+                                <path d="M8 4a.5.5 0 0 1 .5.5V6H10a.5.5 0 0 1 0 1H8.5v1.5a.5.5 0 0 1-1 0V7H6a.5.5 0 0 1 0-1h1.5V4.5A.5.5 0 0 1 8 4zm-2.5 6.5A.5.5 0 0 1 6 10h4a.5.5 0 0 1 0 1H6a.5.5 0 0 1-.5-.5z"/>
+                                <path d="M2 2a2 2 0 0 1 2-2h8a2 2 0 0 1 2 2v12a2 2 0 0 1-2 2H4a2 2 0 0 1-2-2V2zm10-1H4a1 1 0 0 0-1 1v12a1 1 0 0 0 1 1h8a1 1 0 0 0 1-1V2a1 1 0 0 0-1-1z"/>
+                            </svg>
+                            Filesystem changes
+                        </a>
+                        <a class="dropdown-item" @click="$emit('top')" :class="container.links.top ? '' : 'disabled'" href="#">
+                            <svg width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-list-task mb-1 mr-2" fill="currentColor" xmlns="http://www.w3.org/2000/svg">
+                                <path fill-rule="evenodd" d="M2 2.5a.5.5 0 0 0-.5.5v1a.5.5 0 0 0 .5.5h1a.5.5 0 0 0 .5-.5V3a.5.5 0 0 0-.5-.5H2zM3 3H2v1h1V3z"/>
+                                <path d="M5 3.5a.5.5 0 0 1 .5-.5h9a.5.5 0 0 1 0 1h-9a.5.5 0 0 1-.5-.5zM5.5 7a.5.5 0 0 0 0 1h9a.5.5 0 0 0 0-1h-9zm0 4a.5.5 0 0 0 0 1h9a.5.5 0 0 0 0-1h-9z"/>
+                                <path fill-rule="evenodd" d="M1.5 7a.5.5 0 0 1 .5-.5h1a.5.5 0 0 1 .5.5v1a.5.5 0 0 1-.5.5H2a.5.5 0 0 1-.5-.5V7zM2 7h1v1H2V7zm0 3.5a.5.5 0 0 0-.5.5v1a.5.5 0 0 0 .5.5h1a.5.5 0 0 0 .5-.5v-1a.5.5 0 0 0-.5-.5H2zm1 .5H2v1h1v-1z"/>
+                            </svg>
+                            Processes
+                        </a>
                         <a class="dropdown-item" @click="$emit('action', container.links.remove)" :class="container.links.remove ? '' : 'disabled'" href="#">
                             <svg width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-trash mb-1 mr-2" fill="currentColor" xmlns="http://www.w3.org/2000/svg">
                                 <path d="M5.5 5.5A.5.5 0 0 1 6 6v6a.5.5 0 0 1-1 0V6a.5.5 0 0 1 .5-.5zm2.5 0a.5.5 0 0 1 .5.5v6a.5.5 0 0 1-1 0V6a.5.5 0 0 1 .5-.5zm3 .5a.5.5 0 0 0-1 0v6a.5.5 0 0 0 1 0V6z"/>
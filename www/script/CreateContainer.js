@@ -0,0 +1,162 @@
+function lines(text) {
+    return text.split('\n').map(l => l.trim()).filter(l => l !== '');
+}
+
+function list(text) {
+    return text.split(',').map(l => l.trim()).filter(l => l !== '');
+}
+
+export var CreateContainer = {
+    template: `
+<div class="card mb-3">
+    <div class="card-header"><strong>New container</strong></div>
+    <div class="card-body">
+        <div class="form-row" v-if="templates.length > 0">
+            <div class="form-group col">
+                <label for="createTemplate">Template</label>
+                <select v-model="templateName" id="createTemplate" class="form-control form-control-sm">
+                    <option value="">None, describe the container below</option>
+                    <option v-for="t in templates" :value="t.name">{{ t.name }}<template v-if="t.description"> - {{ t.description }}</template></option>
+                </select>
+            </div>
+        </div>
+        <div class="form-row" v-if="selectedTemplate">
+            <div class="form-group col" v-for="p in selectedTemplate.parameters">
+                <label :for="'param-' + p.name">{{ p.name }}<template v-if="p.required"> *</template></label>
+                <input v-model="parameters[p.name]" :id="'param-' + p.name" type="text" class="form-control form-control-sm" :placeholder="p.default" :pattern="p.pattern">
+                <small class="form-text text-muted" v-if="p.description">{{ p.description }}</small>
+            </div>
+        </div>
+        <div v-if="!selectedTemplate">
+            <div class="form-row">
+                <div class="form-group col">
+                    <label for="createImage">Image</label>
+                    <input v-model="image" id="createImage" type="text" class="form-control form-control-sm" placeholder="alpine:3.13">
+                </div>
+                <div class="form-group col">
+                    <label for="createName">Name</label>
+                    <input v-model="name" id="createName" type="text" class="form-control form-control-sm">
+                </div>
+                <div class="form-group col">
+                    <label for="createCmd">Command</label>
+                    <input v-model="cmd" id="createCmd" type="text" class="form-control form-control-sm" placeholder="sleep 3600">
+                </div>
+                <div class="form-group col">
+                    <label for="createRestart">Restart policy</label>
+                    <select v-model="restartPolicy" id="createRestart" class="form-control form-control-sm">
+                        <option value="no">no</option>
+                        <option value="on-failure">on-failure</option>
+                        <option value="unless-stopped">unless-stopped</option>
+                        <option value="always">always</option>
+                    </select>
+                </div>
+            </div>
+            <div class="form-row">
+                <div class="form-group col">
+                    <label for="createPorts">Ports</label>
+                    <input v-model="ports" id="createPorts" type="text" class="form-control form-control-sm" placeholder="8080:80, 5432">
+                </div>
+                <div class="form-group col">
+                    <label for="createNetworks">Networks</label>
+                    <input v-model="networks" id="createNetworks" type="text" class="form-control form-control-sm" placeholder="backend, frontend">
+                </div>
+            </div>
+            <div class="form-row">
+                <div class="form-group col">
+                    <label for="createEnv">Environment</label>
+                    <textarea v-model="env" id="createEnv" class="form-control form-control-sm" rows="3" placeholder="KEY=value, one per line"></textarea>
+                </div>
+                <div class="form-group col">
+                    <label for="createMounts">Mounts</label>
+                    <textarea v-model="mounts" id="createMounts" class="form-control form-control-sm" rows="3" placeholder="volume:data:/data, bind:/srv/in:/in:ro or tmpfs::/tmp, one per line"></textarea>
+                </div>
+                <div class="form-group col">
+                    <label for="createLabels">Labels</label>
+                    <textarea v-model="labels" id="createLabels" class="form-control form-control-sm" rows="3" placeholder="key=value, one per line"></textarea>
+                </div>
+            </div>
+        </div>
+        <button class="btn btn-sm btn-primary" :disabled="busy || (!selectedTemplate && image === '')" @click="create">Create and start</button>
+        <button class="btn btn-sm btn-outline-secondary" @click="$emit('close')">Cancel</button>
+    </div>
+</div>
+    `,
+    data: function () {
+        return {
+            image: '',
+            name: '',
+            cmd: '',
+            restartPolicy: 'no',
+            ports: '',
+            networks: '',
+            env: '',
+            mounts: '',
+            labels: '',
+            templates: [],
+            templateName: '',
+            parameters: {},
+            busy: false
+        };
+    },
+    computed: {
+        selectedTemplate: function () {
+            return this.templates.find(t => t.name === this.templateName);
+        }
+    },
+    watch: {
+        templateName: function () {
+            this.parameters = {};
+        }
+    },
+    created: async function () {
+        try {
+            let response = await fetch('api/templates');
+            if (!response.ok) {
+                this.$emit('failed', response);
+                return;
+            }
+            this.templates = await response.json();
+        } catch (e) {
+            this.$emit('failed', null, e);
+        }
+    },
+    methods: {
+        request: function () {
+            let labels = {};
+            lines(this.labels).forEach(l => {
+                let i = l.indexOf('=');
+                labels[i < 0 ? l : l.slice(0, i)] = i < 0 ? '' : l.slice(i + 1);
+            });
+            return {
+                name: this.name,
+                image: this.image,
+                cmd: this.cmd.split(/\s+/).filter(c => c !== ''),
+                env: lines(this.env),
+                ports: list(this.ports),
+                networks: list(this.networks),
+                restartPolicy: this.restartPolicy,
+                labels: labels,
+                mounts: lines(this.mounts).map(m => {
+                    let [type, source, target, mode] = m.split(':');
+                    return { type: type, source: source, target: target, readOnly: mode === 'ro' };
+                })
+            };
+        },
+        create: async function () {
+            this.busy = true;
+            try {
+                let t = this.selectedTemplate;
+                let response = await fetch(t ? t.links.instantiate.href : 'api/containers', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(t ? { parameters: this.parameters } : this.request())
+                });
+                this.$emit(response.ok ? 'created' : 'failed', response);
+            } catch (e) {
+                this.$emit('failed', null, e);
+            } finally {
+                this.busy = false;
+            }
+        }
+    }
+}
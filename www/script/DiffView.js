@@ -0,0 +1,65 @@
+const kindClasses = { added: 'badge-success', changed: 'badge-warning', deleted: 'badge-danger' };
+
+export var DiffView = {
+    props: ['container'],
+    template: `
+<div class="card mb-3">
+    <div class="card-header">
+        <div class="form-row align-items-center">
+            <div class="col">
+                <strong>Filesystem changes</strong>
+                <span class="badge badge-light ml-1">{{ container.name }}</span>
+                <span v-if="diff" class="text-muted ml-2">{{ diff.added }} added, {{ diff.changed }} changed, {{ diff.deleted }} deleted</span>
+            </div>
+            <div class="col-auto">
+                <button class="btn btn-sm btn-outline-primary" @click="load">Refresh</button>
+                <a v-if="diff && diff.links.export" class="btn btn-sm btn-outline-secondary" :href="diff.links.export.href" download>Export tar</a>
+                <button class="btn btn-sm btn-outline-secondary" @click="$emit('close')">Close</button>
+            </div>
+        </div>
+    </div>
+    <div class="card-body p-2 conman-files">
+        <div v-if="diff && diff.directories.length === 0" class="text-muted">No changes, the container only writes to volumes.</div>
+        <template v-if="diff">
+            <div v-for="dir in diff.directories" class="mb-2">
+                <div class="font-weight-bold">{{ dir.path }}</div>
+                <div v-for="change in dir.changes" class="ml-3">
+                    <span class="badge" :class="kindClass(change.kind)">{{ change.kind }}</span>
+                    {{ change.path }}
+                </div>
+            </div>
+        </template>
+    </div>
+</div>
+    `,
+    data: function () {
+        return {
+            diff: null
+        };
+    },
+    watch: {
+        container: function () {
+            this.load();
+        }
+    },
+    created: function () {
+        this.load();
+    },
+    methods: {
+        kindClass: function (kind) {
+            return kindClasses[kind] || 'badge-secondary';
+        },
+        load: async function () {
+            try {
+                let response = await fetch(this.container.links.diff.href);
+                if (!response.ok) {
+                    this.$emit('failed', response);
+                    return;
+                }
+                this.diff = await response.json();
+            } catch (e) {
+                this.$emit('failed', null, e);
+            }
+        }
+    }
+}
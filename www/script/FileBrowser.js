@@ -0,0 +1,120 @@
+function query(link, path, extra) {
+    return link.href + '?path=' + encodeURIComponent(path) + (extra || '');
+}
+
+function size(bytes) {
+    let units = ['B', 'kB', 'MB', 'GB'];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return (i === 0 ? bytes : bytes.toFixed(1)) + ' ' + units[i];
+}
+
+export var FileBrowser = {
+    props: ['container'],
+    template: `
+<div class="card mb-3">
+    <div class="card-header">
+        <div class="form-row align-items-center">
+            <div class="col-auto">
+                <strong>Files</strong>
+                <span class="badge badge-light ml-1">{{ container.name }}</span>
+            </div>
+            <div class="col">
+                <input v-model="path" @keyup.enter="load(path)" type="text" class="form-control form-control-sm">
+            </div>
+            <div class="col-auto" v-if="listing">
+                <button class="btn btn-sm btn-outline-secondary" :disabled="listing.path === '/'" @click="load(parent)">Up</button>
+                <a class="btn btn-sm btn-outline-secondary" :href="download(listing.path, '&format=tar')" download>Tar</a>
+                <a class="btn btn-sm btn-outline-secondary" :href="download(listing.path, '&format=zip')" download>Zip</a>
+                <label class="btn btn-sm btn-outline-primary mb-0" :class="busy ? 'disabled' : ''">
+                    Upload<input type="file" multiple hidden :disabled="busy" @change="upload">
+                </label>
+            </div>
+            <div class="col-auto">
+                <button class="btn btn-sm btn-outline-secondary" @click="$emit('close')">Close</button>
+            </div>
+        </div>
+    </div>
+    <div class="card-body p-2 conman-files">
+        <div v-if="listing && listing.truncated" class="text-muted small mb-1">The directory is too large to list completely.</div>
+        <table v-if="listing" class="table table-sm mb-0">
+            <tbody>
+                <tr v-for="entry in listing.entries">
+                    <td class="text-monospace text-muted">{{ entry.mode }}</td>
+                    <td>
+                        <a v-if="entry.dir || entry.linkTarget" href="#" @click.prevent="load(entry.path)">{{ entry.name }}{{ entry.dir ? '/' : '' }}</a>
+                        <a v-else :href="download(entry.path)" download>{{ entry.name }}</a>
+                        <span v-if="entry.linkTarget" class="text-muted">&rarr; {{ entry.linkTarget }}</span>
+                    </td>
+                    <td class="text-right">{{ entry.dir ? '' : size(entry.size) }}</td>
+                    <td class="text-muted">{{ new Date(entry.modTime).toLocaleString() }}</td>
+                </tr>
+            </tbody>
+        </table>
+    </div>
+</div>
+    `,
+    data: function () {
+        return {
+            path: '/',
+            listing: null,
+            busy: false
+        };
+    },
+    computed: {
+        parent: function () {
+            let p = this.listing.path.replace(/\/[^/]*$/, '');
+            return p === '' ? '/' : p;
+        }
+    },
+    watch: {
+        container: function () {
+            this.load('/');
+        }
+    },
+    created: function () {
+        this.load('/');
+    },
+    methods: {
+        size: size,
+        download: function (path, extra) {
+            return query(this.listing.links.download, path, extra);
+        },
+        load: async function (path) {
+            try {
+                let response = await fetch(query(this.container.links.files, path));
+                if (!response.ok) {
+                    this.$emit('failed', response);
+                    return;
+                }
+                this.listing = await response.json();
+                this.path = this.listing.path;
+            } catch (e) {
+                this.$emit('failed', null, e);
+            }
+        },
+        upload: async function (e) {
+            let form = new FormData();
+            for (let f of e.target.files) {
+                form.append('file', f);
+            }
+            e.target.value = '';
+            this.busy = true;
+            try {
+                let response = await fetch(query(this.listing.links.upload, this.listing.path), { method: 'POST', body: form });
+                if (!response.ok) {
+                    this.$emit('failed', response);
+                    return;
+                }
+                this.load(this.listing.path);
+            } catch (e) {
+                this.$emit('failed', null, e);
+            } finally {
+                this.busy = false;
+            }
+        }
+    }
+}
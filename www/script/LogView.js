@@ -0,0 +1,191 @@
+const colors = ['#0072b2', '#d55e00', '#009e73', '#cc79a7', '#e69f00', '#56b4e9', '#7f7f7f', '#8c564b'];
+const maxLines = 5000;
+
+export var LogView = {
+    props: ['containers'],
+    template: `
+<div class="card mb-3">
+    <div class="card-header">
+        <div class="form-row align-items-center">
+            <div class="col-auto">
+                <strong>Logs</strong>
+                <span v-for="(c, i) in containers" class="badge badge-light ml-1" :style="{ color: color(i) }">{{ c.name }}</span>
+            </div>
+            <div class="col">
+                <input v-model="level" type="text" class="form-control form-control-sm" placeholder="Levels, e.g. error,warn">
+            </div>
+            <div class="col">
+                <input v-model="pattern" type="text" class="form-control form-control-sm" placeholder="Regular expression">
+            </div>
+            <div class="col">
+                <input v-model="fieldFilter" type="text" class="form-control form-control-sm" placeholder="JSON fields, e.g. level=error">
+            </div>
+            <div class="col">
+                <input v-model="columns" type="text" class="form-control form-control-sm" placeholder="Columns, e.g. level,msg">
+            </div>
+            <div class="col-auto">
+                <button class="btn btn-sm btn-outline-primary" @click="start">Apply</button>
+                <button class="btn btn-sm btn-outline-secondary" @click="exportLines('csv')">CSV</button>
+                <button class="btn btn-sm btn-outline-secondary" @click="exportLines('ndjson')">NDJSON</button>
+                <button class="btn btn-sm btn-outline-secondary" @click="$emit('close')">Close</button>
+            </div>
+        </div>
+    </div>
+    <div class="card-body p-2 conman-log" ref="log">
+        <table v-if="projected.length > 0" class="table table-sm mb-0">
+            <thead>
+                <tr>
+                    <th>Time</th>
+                    <th>Name</th>
+                    <th v-for="f in projected">{{ f }}</th>
+                </tr>
+            </thead>
+            <tbody>
+                <tr v-for="line in lines">
+                    <td class="text-muted">{{ line.time }}</td>
+                    <td class="font-weight-bold" :style="{ color: line.color }">{{ line.name }}</td>
+                    <td v-if="line.error" class="text-danger" :colspan="projected.length">{{ line.error }}</td>
+                    <td v-else v-for="f in projected">{{ fieldValue(line, f) }}</td>
+                </tr>
+            </tbody>
+        </table>
+        <div v-else v-for="line in lines" :class="line.stream === 'stderr' ? 'conman-log-stderr' : ''">
+            <span class="text-muted">{{ line.time }}</span>
+            <span class="font-weight-bold" :style="{ color: line.color }">{{ line.name }}</span>
+            <span v-if="line.error" class="text-danger">{{ line.error }}</span>
+            <span v-else>{{ line.line }}</span>
+        </div>
+    </div>
+</div>
+    `,
+    data: function () {
+        return {
+            level: '',
+            pattern: '',
+            fieldFilter: '',
+            columns: '',
+            projected: [],
+            lines: [],
+            controller: null
+        };
+    },
+    watch: {
+        containers: function () {
+            this.start();
+        }
+    },
+    mounted: function () {
+        this.start();
+    },
+    beforeDestroy: function () {
+        this.stop();
+    },
+    methods: {
+        color: function (i) {
+            return colors[i % colors.length];
+        },
+        fieldValue: function (line, field) {
+            let v = (line.fields || {})[field];
+            if (v === undefined || v === null) {
+                return '';
+            }
+            return typeof v === 'object' ? JSON.stringify(v) : String(v);
+        },
+        exportLines: function (format) {
+            let body;
+            if (format === 'csv') {
+                let quote = v => '"' + String(v).replace(/"/g, '""') + '"';
+                let columns = this.projected.length > 0 ? this.projected : ['line'];
+                let rows = [['time', 'name', 'stream'].concat(columns)];
+                this.lines.forEach(l => rows.push([l.timestamp || '', l.name, l.stream || ''].concat(
+                    this.projected.length > 0 ? this.projected.map(f => this.fieldValue(l, f)) : [l.error || l.line])));
+                body = rows.map(r => r.map(quote).join(',')).join('\r\n') + '\r\n';
+            } else {
+                body = this.lines.map(l => JSON.stringify({
+                    time: l.timestamp, targetType: l.targetType, id: l.id, name: l.name, stream: l.stream,
+                    line: l.line, fields: l.fields, error: l.error
+                })).join('\n') + '\n';
+            }
+            let a = document.createElement('a');
+            a.href = URL.createObjectURL(new Blob([body], { type: format === 'csv' ? 'text/csv' : 'application/x-ndjson' }));
+            a.download = 'logs.' + format;
+            a.click();
+            URL.revokeObjectURL(a.href);
+        },
+        stop: function () {
+            if (this.controller) {
+                this.controller.abort();
+                this.controller = null;
+            }
+        },
+        start: async function () {
+            this.stop();
+            this.lines = [];
+            if (this.containers.length === 0) {
+                return;
+            }
+            let params = new URLSearchParams();
+            this.containers.forEach(c => params.append('container', c.id));
+            if (this.level) {
+                params.set('level', this.level);
+            }
+            if (this.pattern) {
+                params.set('q', this.pattern);
+                params.set('regex', 'true');
+            }
+            this.fieldFilter.split(/\s+/).filter(f => f !== '').forEach(f => params.append('field', f));
+            this.projected = this.columns.split(',').map(c => c.trim()).filter(c => c !== '');
+            if (this.projected.length > 0) {
+                params.set('fields', this.projected.join(','));
+            }
+            let colorOf = {};
+            this.containers.forEach((c, i) => colorOf[c.id] = this.color(i));
+
+            let controller = new AbortController();
+            this.controller = controller;
+            let response;
+            try {
+                response = await fetch('api/logs/follow?' + params.toString(), { signal: controller.signal });
+            } catch (e) {
+                return;
+            }
+            if (!response.ok) {
+                let body = await response.json().catch(() => ({ message: response.statusText }));
+                this.lines.push({ name: 'conman', error: body.message });
+                return;
+            }
+            let reader = response.body.getReader();
+            let decoder = new TextDecoder();
+            let buffer = '';
+            try {
+                for (;;) {
+                    let { value, done } = await reader.read();
+                    if (done) {
+                        break;
+                    }
+                    buffer += decoder.decode(value, { stream: true });
+                    let parts = buffer.split('\n');
+                    buffer = parts.pop();
+                    parts.filter(p => p !== '').forEach(p => {
+                        let e = JSON.parse(p);
+                        e.color = colorOf[e.id];
+                        e.timestamp = e.time;
+                        e.time = e.time ? new Date(e.time).toLocaleTimeString() : '';
+                        this.lines.push(e);
+                    });
+                    if (this.lines.length > maxLines) {
+                        this.lines.splice(0, this.lines.length - maxLines);
+                    }
+                    this.$nextTick(() => {
+                        let el = this.$refs.log;
+                        if (el) {
+                            el.scrollTop = el.scrollHeight;
+                        }
+                    });
+                }
+            } catch (e) {
+                // Aborted when the view is closed or the filters change.
+            }
+        }
+    }
+}
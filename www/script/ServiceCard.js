@@ -15,6 +15,8 @@ export var ServiceCard = {
         </div>
         <div class="col-auto">
             <div class="row text-right text-nowrap">
+                <button v-if="service.links.update" class="btn btn-outline-primary btn-sm mr-2" @click="$emit('action', service.links.update)"
+                    title="Redeploy the service with the latest image of its tag">Update</button>
                 <a class="btn btn-primary btn-sm" :href="service.links.downloadLog.href" download><svg
                         width="1em" height="1em" viewBox="0 0 16 16" class="bi bi-download mb-1 mr-2"
                         fill="currentColor" xmlns="http://www.w3.org/2000/svg">
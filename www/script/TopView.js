@@ -0,0 +1,103 @@
+export var TopView = {
+    props: ['container'],
+    template: `
+<div class="card mb-3">
+    <div class="card-header">
+        <div class="form-row align-items-center">
+            <div class="col-auto">
+                <strong>Processes</strong>
+                <span class="badge badge-light ml-1">{{ container.name }}</span>
+            </div>
+            <div class="col">
+                <input v-model="psArgs" @keyup.enter="load" type="text" class="form-control form-control-sm" title="ps arguments">
+            </div>
+            <div class="col-auto">
+                <select v-if="top && top.signals" v-model="signal" class="custom-select custom-select-sm w-auto">
+                    <option v-for="s in top.signals" :value="s">SIG{{ s }}</option>
+                </select>
+                <button class="btn btn-sm btn-outline-primary" @click="load">Refresh</button>
+                <button class="btn btn-sm btn-outline-secondary" @click="$emit('close')">Close</button>
+            </div>
+        </div>
+    </div>
+    <div class="card-body p-2 conman-files">
+        <table v-if="top" class="table table-sm mb-0">
+            <thead>
+                <tr>
+                    <th>PID</th>
+                    <th>User</th>
+                    <th class="text-right">CPU %</th>
+                    <th class="text-right">Mem %</th>
+                    <th>Command</th>
+                    <th v-if="top.signals"></th>
+                </tr>
+            </thead>
+            <tbody>
+                <tr v-for="p in top.processes">
+                    <td>{{ p.pid }}</td>
+                    <td>{{ p.user }}</td>
+                    <td class="text-right">{{ p.cpu.toFixed(1) }}</td>
+                    <td class="text-right">{{ p.memory.toFixed(1) }}</td>
+                    <td class="text-monospace">{{ p.command }}</td>
+                    <td v-if="top.signals" class="text-right">
+                        <button v-if="p.links.signal" class="btn btn-sm btn-outline-danger py-0" :disabled="busy" @click="send(p)">Send</button>
+                    </td>
+                </tr>
+            </tbody>
+        </table>
+    </div>
+</div>
+    `,
+    data: function () {
+        return {
+            psArgs: 'aux',
+            signal: 'TERM',
+            top: null,
+            busy: false
+        };
+    },
+    watch: {
+        container: function () {
+            this.load();
+        }
+    },
+    created: function () {
+        this.load();
+    },
+    methods: {
+        load: async function () {
+            try {
+                let response = await fetch(this.container.links.top.href + '?psArgs=' + encodeURIComponent(this.psArgs));
+                if (!response.ok) {
+                    this.$emit('failed', response);
+                    return;
+                }
+                this.top = await response.json();
+            } catch (e) {
+                this.$emit('failed', null, e);
+            }
+        },
+        send: async function (p) {
+            if (!window.confirm('Send SIG' + this.signal + ' to process ' + p.pid + ' (' + p.command + ')?')) {
+                return;
+            }
+            this.busy = true;
+            try {
+                let response = await fetch(p.links.signal.href, {
+                    method: p.links.signal.type,
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ signal: this.signal })
+                });
+                if (!response.ok) {
+                    this.$emit('failed', response);
+                    return;
+                }
+                this.load();
+            } catch (e) {
+                this.$emit('failed', null, e);
+            } finally {
+                this.busy = false;
+            }
+        }
+    }
+}
@@ -1,8 +1,53 @@
 import { ServiceCard } from './ServiceCard.js'
 import { ContainerCard } from './ContainerCard.js'
+import { LogView } from './LogView.js'
+import { CreateContainer } from './CreateContainer.js'
+import { FileBrowser } from './FileBrowser.js'
+import { DiffView } from './DiffView.js'
+import { TopView } from './TopView.js'
 
 var app;
 
+// listPageSize is the page size of list requests, further pages are followed
+// through the next link.
+const listPageSize = 100;
+
+function nextLink(response) {
+    let m = /<([^>]+)>;\s*rel="next"/.exec(response.headers.get('Link') || '');
+    return m ? m[1] : null;
+}
+
+// listQuery turns the words of the filter box prefixed with name:, image:,
+// state: or label: into list parameters.
+function listQuery(filter, sort) {
+    let q = new URLSearchParams();
+    for (let word of filter.split(/\s+/)) {
+        let m = /^(name|image|state|label):(.+)$/.exec(word);
+        if (m) {
+            q.append(m[1], m[2]);
+        }
+    }
+    if (sort) {
+        q.set('sort', sort);
+    }
+    q.set('limit', listPageSize);
+    return q;
+}
+
+// matchesFilter reports if every other word of the filter box is part of
+// one of the fields of item, ignoring case.
+function matchesFilter(item, filter, fields) {
+    for (let word of filter.toLowerCase().split(/\s+/)) {
+        if (word === '' || /^(name|image|state|label):/.test(word)) {
+            continue;
+        }
+        if (!fields.some(f => (item[f] || '').toLowerCase().indexOf(word) > -1)) {
+            return false;
+        }
+    }
+    return true;
+}
+
 function init() {
     app = new Vue({
         el: '#app',
@@ -10,15 +55,38 @@ function init() {
             services: [],
             containers: [],
             filterValue: '',
+            sortValue: '',
+            filterTimer: null,
+            loadSeq: 0,
             settings: {
                 autoUpdate: false,
                 swarmMode: false
             },
-            intervalID: null
+            intervalID: null,
+            error: null,
+            selected: [],
+            following: [],
+            creating: false,
+            browsing: null,
+            diffing: null,
+            processes: null
+        },
+        computed: {
+            filteredContainers: function () {
+                return this.containers.filter(c => matchesFilter(c, this.filterValue, ['name', 'image', 'state']));
+            },
+            filteredServices: function () {
+                return this.services.filter(s => matchesFilter(s, this.filterValue, ['name', 'image']));
+            }
         },
         components: {
             'service-card': ServiceCard,
-            'container-card': ContainerCard
+            'container-card': ContainerCard,
+            'log-view': LogView,
+            'create-container': CreateContainer,
+            'file-browser': FileBrowser,
+            'diff-view': DiffView,
+            'top-view': TopView
         },
         watch: {
             'settings.autoUpdate': function (newVal, oldVal) {
@@ -36,40 +104,20 @@ function init() {
             },
             'settings.swarmMode': function (newVal, oldVal) {
                 this.saveSettings();
+                if (newVal && this.sortValue === 'state') {
+                    this.sortValue = '';
+                }
                 this.loadData();
-            }
-        },
-        computed: {
-            'filteredContainers': function () {
-                let v = this.filterValue.toLowerCase();
-                return this.containers.filter(function (cont) {
-                    if (v === '') {
-                        return cont;
-                    }
-                    if (cont.name.toLowerCase().indexOf(v) > -1) {
-                        return cont;
-                    }
-                    if (cont.image.toLowerCase().indexOf(v) > -1) {
-                        return cont;
-                    }
-                    if (cont.state.toLowerCase().indexOf(v) > -1) {
-                        return cont;
-                    }
-                });
             },
-            'filteredServices': function () {
-                let v = this.filterValue.toLowerCase();
-                return this.services.filter(function (svc) {
-                    if (v === '') {
-                        return svc;
-                    }
-                    if (svc.name.toLowerCase().indexOf(v) > -1) {
-                        return svc;
-                    }
-                    if (svc.image.toLowerCase().indexOf(v) > -1) {
-                        return svc;
-                    }
-                });
+            filterValue: function (newVal, oldVal) {
+                if (listQuery(newVal).toString() === listQuery(oldVal).toString()) {
+                    return;
+                }
+                window.clearTimeout(this.filterTimer);
+                this.filterTimer = window.setTimeout(this.loadData, 300);
+            },
+            sortValue: function () {
+                this.loadData();
             }
         },
         beforeMount: async function () {
@@ -95,21 +143,46 @@ function init() {
                 }
             },
             loadData: async function () {
+                let seq = ++this.loadSeq;
+                let items = await this.loadList(this.settings.swarmMode ? 'api/services' : 'api/containers');
+                if (items === null || seq !== this.loadSeq) {
+                    return;
+                }
                 if (this.settings.swarmMode) {
-                    let response = await fetch('api/services');
-                    if (response.ok) {
-                        this.services = await response.json();
-                    }
+                    this.services = items;
                 } else {
-                    let response = await fetch('api/containers');
-                    if (response.ok) {
-                        this.containers = await response.json();
+                    this.containers = items;
+                }
+            },
+            loadList: async function (path) {
+                let items = [];
+                let url = path + '?' + listQuery(this.filterValue, this.sortValue).toString();
+                try {
+                    while (url) {
+                        let response = await fetch(url);
+                        if (!response.ok) {
+                            this.requestFailed(response);
+                            return null;
+                        }
+                        items = items.concat(await response.json());
+                        url = nextLink(response);
                     }
+                } catch (e) {
+                    this.requestFailed(null, e);
+                    return null;
                 }
+                return items;
             },
             action: async function (link) {
-                let response = await fetch(link.href, { method: link.type });
+                let response;
+                try {
+                    response = await fetch(link.href, { method: link.type });
+                } catch (e) {
+                    this.error = { message: 'Could not reach ConMan: ' + e.message };
+                    return;
+                }
                 if (response.ok) {
+                    this.error = null;
                     switch (response.status) {
                         case 200:
                             this.log = await response.text()
@@ -120,6 +193,36 @@ function init() {
                     this.loadData();
                     return;
                 }
+                this.error = await this.errorFromResponse(response);
+            },
+            select: function (container, checked) {
+                this.selected = this.selected.filter(id => id !== container.id);
+                if (checked) {
+                    this.selected.push(container.id);
+                }
+            },
+            followLogs: function () {
+                this.following = this.containers.filter(c => this.selected.includes(c.id));
+            },
+            created: function () {
+                this.creating = false;
+                this.error = null;
+                this.loadData();
+            },
+            requestFailed: async function (response, e) {
+                if (response) {
+                    this.error = await this.errorFromResponse(response);
+                } else {
+                    this.error = { message: 'Could not reach ConMan: ' + e.message };
+                }
+            },
+            errorFromResponse: async function (response) {
+                try {
+                    let body = await response.json();
+                    return { message: body.message, requestId: body.requestId };
+                } catch (e) {
+                    return { message: response.status + ' ' + response.statusText };
+                }
             }
             // downloadLog: async function (link) {
             //     response = await fetch(link.href, { method: link.type });